@@ -0,0 +1,370 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/config"
+	"github.com/pedromol/bashhub-server/internal/db"
+	"github.com/pedromol/bashhub-server/internal/server"
+)
+
+// console is what an administrative subcommand runs against: the effective
+// configuration, its database and the process's standard streams. ctx is
+// cancelled on interrupt.
+type console struct {
+	ctx   context.Context
+	cfg   config.Config
+	store db.Store
+	in    io.Reader
+	out   io.Writer
+}
+
+type subcommand struct {
+	usage string
+	run   func(c *console, args []string) error
+	// migrates marks commands that manage the schema themselves, so the
+	// database is opened without migrating it.
+	migrates bool
+	// offline marks commands that do not use the database.
+	offline bool
+}
+
+// subcommands are the administrative commands, by group and name, that
+// operate directly on the database instead of starting the server.
+var subcommands = map[string]map[string]subcommand{
+	"config": {
+		"print": {usage: "[-show-secrets]", run: configPrint, offline: true},
+	},
+	"user": {
+		"add":            {usage: "[-admin] [-password password] <username> <email>", run: userAdd},
+		"list":           {usage: "[-json]", run: userList},
+		"delete":         {usage: "<username>", run: userDelete},
+		"reset-password": {usage: "[-password password] <username>", run: userResetPassword},
+	},
+	"token": {
+		"issue": {usage: "[-lifetime duration] <username>", run: tokenIssue},
+	},
+	"db": {
+		"migrate": {usage: "[-status]", run: dbMigrate, migrates: true},
+		"vacuum":  {run: dbVacuum},
+		"stats":   {usage: "[-json]", run: dbStats},
+	},
+}
+
+// runCommand executes the subcommand named by args against the configured
+// database. Passwords not given with -password are read from in.
+func runCommand(args []string, cfg config.Config, in io.Reader, out io.Writer) error {
+	group, ok := subcommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage())
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("%s requires a subcommand\n%s", args[0], commandUsage())
+	}
+	cmd, ok := group[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0]+" "+args[1], commandUsage())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := &console{ctx: ctx, cfg: cfg, in: in, out: out}
+	if !cmd.offline {
+		opts := db.Options{SkipMigrations: !cfg.Migrate, ConnectTimeout: cfg.DBConnectTimeout}
+		if cmd.migrates {
+			opts.SkipMigrations, opts.AllowOutdated = true, true
+		}
+		store, err := db.Open(cfg.DB, opts)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		c.store = store
+	}
+	if err := cmd.run(c, args[2:]); err != nil {
+		if err == errUsage {
+			return fmt.Errorf("usage: bashhub-server %s %s %s", args[0], args[1], cmd.usage)
+		}
+		return err
+	}
+	return nil
+}
+
+// errUsage is returned by a subcommand given the wrong arguments.
+var errUsage = fmt.Errorf("invalid arguments")
+
+func commandUsage() string {
+	var lines []string
+	for name, group := range subcommands {
+		for sub, cmd := range group {
+			lines = append(lines, strings.TrimSpace("  bashhub-server "+name+" "+sub+" "+cmd.usage))
+		}
+	}
+	sort.Strings(lines)
+	return "Commands:\n" + strings.Join(lines, "\n")
+}
+
+// parseArgs parses the flags of a subcommand and checks it got exactly n
+// positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil || fs.NArg() != n {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// password returns value, or the first line of the console's input when it
+// is empty.
+func (c *console) password(value string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	line, err := bufio.NewReader(c.in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if line = strings.TrimRight(line, "\r\n"); line == "" {
+		return "", fmt.Errorf("password required, pass -password or write it to stdin")
+	}
+	return line, nil
+}
+
+func (c *console) userID(username string) (uint, error) {
+	id, err := c.store.UserGetID(c.ctx, db.User{Username: username})
+	if errors.Is(err, db.ErrNotFound) {
+		return 0, fmt.Errorf("user %q not found", username)
+	}
+	return id, err
+}
+
+func userAdd(c *console, args []string) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	admin := fs.Bool("admin", false, "")
+	password := fs.String("password", "", "")
+	args, err := parseArgs(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	user := db.User{Username: args[0], Email: args[1]}
+	if exists, err := c.store.UsernameExists(c.ctx, user); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("username %q already taken", user.Username)
+	}
+	if exists, err := c.store.EmailExists(c.ctx, user); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("email %q already registered", user.Email)
+	}
+	if user.Password, err = c.password(*password); err != nil {
+		return err
+	}
+	if _, err := c.store.UserCreate(c.ctx, user); err != nil {
+		return err
+	}
+	id, err := c.userID(user.Username)
+	if err != nil {
+		return err
+	}
+	if *admin {
+		if _, err := c.store.UserSetAdmin(c.ctx, id, true); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(c.out, "created user %s (id %d)\n", user.Username, id)
+	return nil
+}
+
+func userList(c *console, args []string) error {
+	fs := flag.NewFlagSet("user list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	users, err := c.store.UserList(c.ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(c.out).Encode(users)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tADMIN\tDISABLED")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%v\t%v\n", u.ID, u.Username, u.Email, u.IsAdmin, u.Disabled)
+	}
+	return w.Flush()
+}
+
+func userDelete(c *console, args []string) error {
+	args, err := parseArgs(flag.NewFlagSet("user delete", flag.ContinueOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := c.userID(args[0])
+	if err != nil {
+		return err
+	}
+	if _, err := c.store.UserDelete(c.ctx, id); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "deleted user %s\n", args[0])
+	return nil
+}
+
+// userResetPassword sets a new password and logs the user out everywhere,
+// like a completed reset through the API.
+func userResetPassword(c *console, args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := c.userID(args[0])
+	if err != nil {
+		return err
+	}
+	newPassword, err := c.password(*password)
+	if err != nil {
+		return err
+	}
+	if _, err := c.store.UserSetPassword(c.ctx, id, newPassword); err != nil {
+		return err
+	}
+	revoked, err := c.store.SessionRevokeAll(c.ctx, id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "password of %s changed, %d sessions revoked\n", args[0], revoked)
+	return nil
+}
+
+// tokenIssue prints an access token for a user, signed with the same keys,
+// issuer and audience the server is configured with.
+func tokenIssue(c *console, args []string) error {
+	fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+	lifetime := fs.Duration("lifetime", 24*time.Hour, "")
+	args, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	opts, err := serverOptions(c.cfg)
+	if err != nil {
+		return err
+	}
+	opts.LogFile = "/dev/null"
+	opts.Admins = nil
+	// Rotating the stored secret is left to the running server, which would
+	// otherwise keep signing with the key rotated away here.
+	opts.JWTRotationInterval = 0
+	srv, err := server.NewServer(c.store, opts)
+	if err != nil {
+		return err
+	}
+	token, err := srv.IssueToken(c.ctx, args[0], *lifetime)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, token)
+	return nil
+}
+
+// configPrint writes the effective configuration in the config file format,
+// with secrets hidden unless -show-secrets is given.
+func configPrint(c *console, args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	showSecrets := fs.Bool("show-secrets", false, "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	return c.cfg.Print(c.out, *showSecrets)
+}
+
+// schemaStore is implemented by stores with a versioned schema.
+type schemaStore interface {
+	SchemaVersion(ctx context.Context) (int, error)
+	PendingMigrations(ctx context.Context) (int, error)
+	Migrate(ctx context.Context) (int, error)
+}
+
+// dbMigrate applies pending migrations, or with -status only reports them.
+func dbMigrate(c *console, args []string) error {
+	fs := flag.NewFlagSet("db migrate", flag.ContinueOnError)
+	status := fs.Bool("status", false, "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	store, ok := c.store.(schemaStore)
+	if !ok {
+		return fmt.Errorf("this database has no schema to migrate")
+	}
+	pending, err := store.PendingMigrations(c.ctx)
+	if err != nil {
+		return err
+	}
+	applied := 0
+	if !*status {
+		if applied, err = store.Migrate(c.ctx); err != nil {
+			return err
+		}
+		pending -= applied
+	}
+	version, err := store.SchemaVersion(c.ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "schema at version %d, %d migrations applied, %d pending\n", version, applied, pending)
+	return nil
+}
+
+func dbVacuum(c *console, args []string) error {
+	if _, err := parseArgs(flag.NewFlagSet("db vacuum", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	vacuumer, ok := c.store.(interface{ Vacuum(context.Context) error })
+	if !ok {
+		return fmt.Errorf("this database does not need vacuuming")
+	}
+	if err := vacuumer.Vacuum(c.ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, "vacuum complete")
+	return nil
+}
+
+func dbStats(c *console, args []string) error {
+	fs := flag.NewFlagSet("db stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	stats, err := c.store.Stats(c.ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(c.out).Encode(stats)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "users\t%d\n", stats.Users)
+	fmt.Fprintf(w, "systems\t%d\n", stats.Systems)
+	fmt.Fprintf(w, "commands\t%d\n", stats.Commands)
+	fmt.Fprintf(w, "active sessions\t%d\n", stats.Sessions)
+	fmt.Fprintf(w, "api keys\t%d\n", stats.APIKeys)
+	fmt.Fprintf(w, "invites\t%d\n", stats.Invites)
+	return w.Flush()
+}
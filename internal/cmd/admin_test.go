@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/config"
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+func run(t *testing.T, dbPath, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cfg := config.Default()
+	cfg.DB = dbPath
+	err := runCommand(args, cfg, strings.NewReader(stdin), &out)
+	return out.String(), err
+}
+
+func TestRunCommand(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "admin.db")
+
+	if _, err := run(t, dbPath, "", "user", "add", "-admin", "-password", "secret", "alice", "alice@example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := run(t, dbPath, "hunter2\n", "user", "add", "bob", "bob@example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := run(t, dbPath, "", "user", "add", "carol", "carol@example.com"); err == nil {
+		t.Errorf("expected error without a password")
+	}
+	if _, err := run(t, dbPath, "", "user", "add", "-password", "x", "alice", "other@example.com"); err == nil {
+		t.Errorf("expected error for a duplicate username")
+	}
+	if _, err := run(t, dbPath, "", "user", "add", "alice"); err == nil || !strings.HasPrefix(err.Error(), "usage: bashhub-server user add") {
+		t.Errorf("expected usage error, got %v", err)
+	}
+
+	out, err := run(t, dbPath, "", "user", "list", "-json")
+	var users []db.User
+	if err != nil || json.Unmarshal([]byte(out), &users) != nil {
+		t.Fatalf("unexpected output %q (%v)", out, err)
+	}
+	if len(users) != 2 || !users[0].IsAdmin || users[1].IsAdmin || users[1].Username != "bob" {
+		t.Errorf("unexpected users %+v", users)
+	}
+	if out, _ := run(t, dbPath, "", "user", "list"); !strings.Contains(out, "USERNAME") || !strings.Contains(out, "bob@example.com") {
+		t.Errorf("unexpected table %q", out)
+	}
+
+	if _, err := run(t, dbPath, "", "user", "reset-password", "-password", "changed", "bob"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, err := db.Open(dbPath, db.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.UserExists(ctx, db.User{Username: "bob", Password: "changed"}); err != nil {
+		t.Errorf("expected new password to work, got %v", err)
+	}
+	store.Close()
+
+	token, err := run(t, dbPath, "", "token", "issue", "-lifetime", "1h", "bob")
+	if err != nil || strings.Count(token, ".") != 2 {
+		t.Errorf("expected a token, got %q (%v)", token, err)
+	}
+	if _, err := run(t, dbPath, "", "token", "issue", "nobody"); err == nil {
+		t.Errorf("expected error for unknown user")
+	}
+	store, err = db.Open(dbPath, db.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bob, _ := store.UserGetID(ctx, db.User{Username: "bob"})
+	store.UserSetDisabled(ctx, bob, true)
+	if _, err := run(t, dbPath, "", "token", "issue", "bob"); err == nil {
+		t.Errorf("expected error for a disabled user")
+	}
+	store.UserSetDisabled(ctx, bob, false)
+	store.Close()
+
+	out, err = run(t, dbPath, "", "db", "stats", "-json")
+	var stats db.Stats
+	if err != nil || json.Unmarshal([]byte(out), &stats) != nil {
+		t.Fatalf("unexpected output %q (%v)", out, err)
+	}
+	if stats.Users != 2 || stats.Sessions != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if out, err := run(t, dbPath, "", "db", "migrate", "-status"); err != nil || !strings.HasSuffix(out, " 0 pending\n") {
+		t.Errorf("expected an up to date schema, got %q (%v)", out, err)
+	}
+	for _, args := range [][]string{{"db", "migrate"}, {"db", "vacuum"}, {"user", "delete", "bob"}} {
+		if _, err := run(t, dbPath, "", args...); err != nil {
+			t.Errorf("%v: unexpected error: %v", args, err)
+		}
+	}
+	if _, err := run(t, dbPath, "", "user", "delete", "bob"); err == nil {
+		t.Errorf("expected error deleting a missing user")
+	}
+	if out, err := run(t, dbPath, "", "config", "print"); err != nil || !strings.Contains(out, `"db": "`+dbPath+`"`) {
+		t.Errorf("expected the effective config, got %q (%v)", out, err)
+	}
+	if _, err := run(t, dbPath, "", "frobnicate"); err == nil {
+		t.Errorf("expected error for an unknown command")
+	}
+}
+
+func TestTokenIssueLeavesRotationToServer(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "admin.db")
+	if _, err := run(t, dbPath, "", "user", "add", "-password", "secret", "alice", "alice@example.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, err := db.Open(dbPath, db.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	before, _ := store.GetSecret(ctx)
+
+	cfg := config.Default()
+	cfg.DB = dbPath
+	cfg.JWTRotationInterval = time.Nanosecond
+	var out bytes.Buffer
+	if err := runCommand([]string{"token", "issue", "alice"}, cfg, strings.NewReader(""), &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if after, _ := store.GetSecret(ctx); after != before {
+		t.Errorf("expected token issue not to rotate the stored secret")
+	}
+}
+
+// unavailableStore is a MemoryStore whose existence checks fail.
+type unavailableStore struct {
+	*db.MemoryStore
+}
+
+func (unavailableStore) UsernameExists(context.Context, db.User) (bool, error) {
+	return false, &db.Error{Op: "check username", Kind: db.ErrUnavailable}
+}
+
+func TestUserAddStoreFailure(t *testing.T) {
+	ctx := context.Background()
+	store := unavailableStore{db.NewMemoryStore()}
+	c := &console{ctx: ctx, cfg: config.Default(), store: store, out: &bytes.Buffer{}}
+	err := userAdd(c, []string{"-password", "secret", "alice", "alice@example.com"})
+	if !errors.Is(err, db.ErrUnavailable) {
+		t.Fatalf("expected the store error, got %v", err)
+	}
+	if users, _ := store.UserList(ctx); len(users) != 0 {
+		t.Errorf("expected no user to be created, got %+v", users)
+	}
+}
@@ -6,22 +6,27 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/pedromol/bashhub-server/internal/config"
 	"github.com/pedromol/bashhub-server/internal/server"
 )
 
 var (
-	logFile      = flag.String("log", "", "log file location")
-	dbPath       = flag.String("db", sqlitePath(), "db location (sqlite or postgres)")
-	addr         = flag.String("addr", listenAddr(), "Ip and port to listen and serve on")
-	registration = flag.Bool("registration", true, "Allow user registration")
-	showVersion  = flag.Bool("version", false, "Show version information")
-	GitCommit    string
-	BuildDate    string
-	Version      string
+	showVersion = flag.Bool("version", false, "Show version information")
+	flags       = config.RegisterFlags(flag.CommandLine, defaultConfig())
+
+	GitCommit string
+	BuildDate string
+	Version   string
 )
 
 func Execute() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintln(flag.CommandLine.Output(), commandUsage())
+	}
 	flag.Parse()
 
 	if *showVersion {
@@ -31,10 +36,127 @@ func Execute() {
 		os.Exit(0)
 	}
 
-	startupMessage()
-	server.Run(*dbPath, *logFile, *addr, *registration)
+	cfg, err := flags.Load(os.Getenv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args(), cfg, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts, err := serverOptions(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Reload = reloadOptions
+	startupMessage(cfg)
+	server.Run(cfg.DB, cfg.Addr, opts)
+}
+
+// defaultConfig is the built-in configuration plus the environment variables
+// that predate BH_* options: BH_SERVER_URL and POSTGRES_URL.
+func defaultConfig() config.Config {
+	cfg := config.Default()
+	cfg.Addr = listenAddr()
+	cfg.DB = sqlitePath()
+	if url := os.Getenv("POSTGRES_URL"); url != "" {
+		cfg.DB = url
+	}
+	return cfg
 }
-func startupMessage() {
+
+// serverOptions builds the server options from the configuration.
+func serverOptions(cfg config.Config) (server.Options, error) {
+	secret, err := resolveSecret(cfg.JWTSecret, cfg.JWTSecretFile)
+	if err != nil {
+		return server.Options{}, err
+	}
+	previousSecret, err := resolveSecret(cfg.JWTPreviousSecret, cfg.JWTPreviousSecretFile)
+	if err != nil {
+		return server.Options{}, err
+	}
+	return server.Options{
+		LogFile:              cfg.Log,
+		LogFormat:            cfg.LogFormat,
+		LogLevel:             cfg.LogLevel,
+		LogMaxSize:           int64(cfg.LogMaxSize) << 20,
+		LogMaxAge:            cfg.LogMaxAge,
+		LogMaxBackups:        cfg.LogMaxBackups,
+		LogCompress:          cfg.LogCompress,
+		Metrics:              cfg.Metrics,
+		MetricsAddr:          cfg.MetricsAddr,
+		Registration:         cfg.Registration,
+		RegistrationMode:     cfg.RegistrationMode,
+		RegistrationDomains:  cfg.RegistrationDomains,
+		JWTSecret:            secret,
+		JWTPreviousSecret:    previousSecret,
+		JWTRotationGrace:     cfg.JWTRotationGrace,
+		JWTRotationInterval:  cfg.JWTRotationInterval,
+		JWTIssuer:            cfg.JWTIssuer,
+		JWTAudience:          cfg.JWTAudience,
+		AccessTokenLifetime:  cfg.AccessTokenLifetime,
+		RefreshTokenLifetime: cfg.RefreshTokenLifetime,
+		LegacyTokenLifetime:  cfg.LegacyTokenLifetime,
+		Admins:               cfg.Admins,
+		FirstUserAdmin:       cfg.FirstUserAdmin,
+		SkipMigrations:       !cfg.Migrate,
+		QueryTimeout:         cfg.QueryTimeout,
+		DBMaxOpenConns:       cfg.DBMaxOpenConns,
+		DBMaxIdleConns:       cfg.DBMaxIdleConns,
+		DBConnMaxLifetime:    cfg.DBConnMaxLifetime,
+		DBConnMaxIdleTime:    cfg.DBConnMaxIdleTime,
+		DBConnectTimeout:     cfg.DBConnectTimeout,
+		DBReadReplica:        cfg.DBReadReplica,
+		ResetDelivery:        cfg.ResetDelivery,
+		TLSCertFile:          cfg.TLSCert,
+		TLSKeyFile:           cfg.TLSKey,
+		TLSClientCA:          cfg.TLSClientCA,
+		TLSRequireClientCert: cfg.TLSRequireClientCert,
+		HTTPRedirectAddr:     cfg.HTTPRedirectAddr,
+		SocketMode:           cfg.SocketFileMode(),
+		ReadTimeout:          cfg.ReadTimeout,
+		WriteTimeout:         cfg.WriteTimeout,
+		IdleTimeout:          cfg.IdleTimeout,
+		ShutdownTimeout:      cfg.ShutdownTimeout,
+	}, nil
+}
+
+// reloadOptions reads the config file and environment again, keeping the
+// command line flags, for the server to apply on SIGHUP.
+func reloadOptions() (server.Options, error) {
+	cfg, err := flags.Load(os.Getenv)
+	if err != nil {
+		return server.Options{}, err
+	}
+	return serverOptions(cfg)
+}
+
+// resolveSecret picks a secret from its configured value, then a key file. An
+// empty result means the db stored secret is used.
+func resolveSecret(value, file string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+	if file == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %v", err)
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("secret file %v is empty", file)
+	}
+	return secret, nil
+}
+func startupMessage(cfg config.Config) {
 	banner := fmt.Sprintf(`
  _               _     _           _
 | |             | |   | |         | |		version: %v
@@ -46,9 +168,13 @@ func startupMessage() {
 / __|/ _ \ '__\ \ / / _ \ '__|
 \__ \  __/ |   \ V /  __/ |
 |___/\___|_|    \_/ \___|_|
-`, Version, *addr, *registration)
+`, Version, cfg.Addr, cfg.Registration)
 	log.Println(banner)
-	log.Printf("\nListening and serving HTTP on %v\n", *addr)
+	protocol := "HTTP"
+	if cfg.TLSCert != "" {
+		protocol = "HTTPS"
+	}
+	log.Printf("\nListening and serving %v on %v\n", protocol, cfg.Addr)
 }
 func listenAddr() string {
 	var a string
@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/pedromol/bashhub-server/internal/config"
+	"github.com/pedromol/bashhub-server/internal/db"
+	"github.com/pedromol/bashhub-server/internal/server"
 )
 
 func TestMain(m *testing.M) {
@@ -80,15 +87,12 @@ func TestAppDir(t *testing.T) {
 }
 func TestStartupMessage(t *testing.T) {
 	originalVersion := Version
-	originalAddr := *addr
-	originalRegistration := *registration
+	cfg := config.Default()
 	Version = "test-version"
-	*addr = "http://test:8080"
-	*registration = true
-	startupMessage()
+	cfg.Addr = "http://test:8080"
+	cfg.Registration = true
+	startupMessage(cfg)
 	Version = originalVersion
-	*addr = originalAddr
-	*registration = originalRegistration
 }
 func TestRootCommand(t *testing.T) {
 	func() {
@@ -97,7 +101,7 @@ func TestRootCommand(t *testing.T) {
 				t.Errorf("function panicked: %v", r)
 			}
 		}()
-		startupMessage()
+		startupMessage(config.Default())
 	}()
 	// Execute function is always non-nil, no need to check
 }
@@ -127,24 +131,24 @@ func TestRootCommandRun(t *testing.T) {
 	// Execute function is always non-nil, test passes
 }
 func TestCommandFlagParsing(t *testing.T) {
-	originalDbPath := *dbPath
-	originalAddr := *addr
-	originalRegistration := *registration
-	*dbPath = "/test/path"
-	*addr = "http://0.0.0.0:8080"
-	*registration = false
-	if *dbPath != "/test/path" {
-		t.Errorf("expected dbPath to be '/test/path', got %v", *dbPath)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs, defaultConfig())
+	if err := fs.Parse([]string{"-db", "/test/path", "-addr", "http://0.0.0.0:8080", "-registration=false"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := flags.Load(func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if *addr != "http://0.0.0.0:8080" {
-		t.Errorf("expected addr to be 'http://0.0.0.0:8080', got %v", *addr)
+	if cfg.DB != "/test/path" {
+		t.Errorf("expected dbPath to be '/test/path', got %v", cfg.DB)
 	}
-	if *registration != false {
-		t.Errorf("expected registration to be false, got %v", *registration)
+	if cfg.Addr != "http://0.0.0.0:8080" {
+		t.Errorf("expected addr to be 'http://0.0.0.0:8080', got %v", cfg.Addr)
+	}
+	if cfg.Registration != false {
+		t.Errorf("expected registration to be false, got %v", cfg.Registration)
 	}
-	*dbPath = originalDbPath
-	*addr = originalAddr
-	*registration = originalRegistration
 }
 func TestVersionCommand(t *testing.T) {
 	originalGitCommit := GitCommit
@@ -182,10 +186,8 @@ func TestInitFunction(t *testing.T) {
 				t.Errorf("function panicked: %v", r)
 			}
 		}()
-		_ = dbPath
-		_ = addr
-		_ = registration
-		_ = logFile
+		_ = flags
+		_ = showVersion
 	}()
 }
 func TestFlagDefaultsWithEnv(t *testing.T) {
@@ -203,23 +205,67 @@ func TestStartupMessageFormat(t *testing.T) {
 				t.Errorf("function panicked: %v", r)
 			}
 		}()
-		startupMessage()
+		startupMessage(config.Default())
 	}()
 	originalVersion := Version
-	originalAddr := *addr
-	originalRegistration := *registration
+	cfg := config.Default()
 	Version = "v1.0.0"
-	*addr = "http://0.0.0.0:8080"
-	*registration = false
+	cfg.Addr = "http://0.0.0.0:8080"
+	cfg.Registration = false
 	func() {
 		defer func() {
 			if r := recover(); r != nil {
 				t.Errorf("function panicked: %v", r)
 			}
 		}()
-		startupMessage()
+		startupMessage(cfg)
 	}()
 	Version = originalVersion
-	*addr = originalAddr
-	*registration = originalRegistration
+}
+func TestResolveSecret(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(file, []byte("from-file\n"), 0600)
+
+	secret, err := resolveSecret("", "")
+	if err != nil || secret != "" {
+		t.Errorf("expected empty secret, got %q (%v)", secret, err)
+	}
+	secret, _ = resolveSecret("", file)
+	if secret != "from-file" {
+		t.Errorf("expected secret from file, got %q", secret)
+	}
+	secret, _ = resolveSecret("from-config", file)
+	if secret != "from-config" {
+		t.Errorf("expected configured secret, got %q", secret)
+	}
+	if _, err := resolveSecret("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected error for missing file, got nil")
+	}
+}
+func TestDefaultConfig(t *testing.T) {
+	os.Setenv("POSTGRES_URL", "postgres://user:pass@db:5432/bashhub")
+	defer os.Unsetenv("POSTGRES_URL")
+	if cfg := defaultConfig(); cfg.DB != "postgres://user:pass@db:5432/bashhub" {
+		t.Errorf("expected POSTGRES_URL to be the default db, got %v", cfg.DB)
+	}
+	os.Unsetenv("POSTGRES_URL")
+	if cfg := defaultConfig(); cfg.DB != sqlitePath() {
+		t.Errorf("expected the sqlite file to be the default db, got %v", cfg.DB)
+	}
+}
+func TestDefaultConfigHidesMetrics(t *testing.T) {
+	opts, err := serverOptions(config.Default())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts.LogFile = os.DevNull
+	s, err := server.NewServer(db.NewMemoryStore(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected %v, got %v", http.StatusNotFound, w.Code)
+	}
 }
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func load(t *testing.T, env map[string]string, args ...string) (Config, error) {
+	t.Helper()
+	defaults := Default()
+	defaults.DB = "default.db"
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs, defaults)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return flags.Load(func(key string) string { return env[key] })
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `{
+		"addr": "http://file:8080",
+		"db": "file.db",
+		"query-timeout": "5s",
+		"admins": ["alice", "bob"],
+		"registration-domains": "example.com, example.org"
+	}`)
+
+	cfg, err := load(t, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DB != "default.db" || cfg.QueryTimeout != 10*time.Second || cfg.Admins != nil {
+		t.Errorf("expected defaults, got %+v", cfg)
+	}
+
+	cfg, err = load(t, nil, "-config", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Addr != "http://file:8080" || cfg.DB != "file.db" || cfg.QueryTimeout != 5*time.Second {
+		t.Errorf("expected values from the file, got %+v", cfg)
+	}
+	if len(cfg.Admins) != 2 || len(cfg.RegistrationDomains) != 2 || cfg.RegistrationDomains[1] != "example.org" {
+		t.Errorf("expected lists from the file, got %q and %q", cfg.Admins, cfg.RegistrationDomains)
+	}
+
+	env := map[string]string{"BH_CONFIG": path, "BH_DB": "env.db", "BH_ADMINS": "carol", "BH_REGISTRATION": "false"}
+	cfg, err = load(t, env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Addr != "http://file:8080" || cfg.DB != "env.db" || len(cfg.Admins) != 1 || cfg.Registration {
+		t.Errorf("expected the environment to override the file, got %+v", cfg)
+	}
+
+	cfg, err = load(t, env, "-db", "flag.db", "-query-timeout", "1m", "-admins", "dave,erin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.DB != "flag.db" || cfg.QueryTimeout != time.Minute || len(cfg.Admins) != 2 || cfg.Admins[0] != "dave" {
+		t.Errorf("expected flags to override the environment, got %+v", cfg)
+	}
+	if cfg.Registration {
+		t.Errorf("expected options not given as flags to keep their environment value")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		message string
+	}{
+		{"missing file", "", map[string]string{"BH_CONFIG": "/does/not/exist.json"}, "failed to read config file"},
+		{"malformed file", `{"db": `, nil, "config file"},
+		{"unknown option", `{"database": "x.db"}`, nil, `unknown option "database"`},
+		{"wrong type", `{"registration": "yes"}`, nil, "expected true or false"},
+		{"bad duration", `{"query-timeout": "soon"}`, nil, `expected a duration such as "30s"`},
+		{"bad env", "", map[string]string{"BH_DB_MAX_OPEN_CONNS": "many"}, "BH_DB_MAX_OPEN_CONNS: invalid value"},
+		{"bad mode", `{"registration-mode": "friends"}`, nil, `registration-mode: must be open, closed, invite or domain, not "friends"`},
+		{"no domains", `{"registration-mode": "domain"}`, nil, "registration-domains: domain registration mode needs at least one domain"},
+		{"negative", `{"db-max-idle-conns": -1}`, nil, "db-max-idle-conns: must not be negative"},
+		{"no lifetime", `{"access-token-lifetime": "0s"}`, nil, "access-token-lifetime: must be longer than 0s"},
+		{"no db", `{"db": ""}`, nil, "db: a database location is required"},
+		{"https without cert", `{"addr": "https://0.0.0.0:443"}`, nil, "addr: an https address needs tls-cert and tls-key"},
+		{"cert without key", `{"tls-cert": "cert.pem"}`, nil, "tls-key: tls-cert and tls-key must be set together"},
+		{"client ca without tls", `{"tls-client-ca": "ca.pem"}`, nil, "tls-client-ca: client certificates need tls-cert and tls-key"},
+		{"redirect without tls", `{"http-redirect-addr": ":80"}`, nil, "http-redirect-addr: redirecting to HTTPS needs tls-cert and tls-key"},
+		{"bad socket mode", `{"socket-mode": "rw-rw----"}`, nil, `socket-mode: must be octal permissions such as 0660, not "rw-rw----"`},
+		{"socket mode too wide", `{"socket-mode": "4777"}`, nil, "socket-mode: must be octal permissions"},
+		{"bad log format", `{"log-format": "xml"}`, nil, `log-format: must be text or json, not "xml"`},
+		{"bad log level", `{"log-level": "verbose"}`, nil, `log-level: must be debug, info, warn or error, not "verbose"`},
+		{"rotating stderr", `{"log-max-size": 100}`, nil, "log: log rotation needs a log file"},
+		{"metrics listener disabled", `{"metrics-addr": ":9100"}`, nil, "metrics-addr: a metrics listener needs metrics enabled"},
+		{"https metrics listener", `{"metrics": true, "metrics-addr": "https://0.0.0.0:9100"}`, nil, "metrics-addr: the metrics listener serves plain HTTP"},
+		{"short secret", `{"jwt-secret": "hunter2"}`, nil, "jwt-secret: must be at least 32 bytes"},
+		{"short previous secret", `{"jwt-previous-secret": "hunter2"}`, nil, "jwt-previous-secret: must be at least 32 bytes"},
+		{"require without ca", `{"tls-cert": "c", "tls-key": "k", "tls-require-client-cert": true}`, nil, "tls-require-client-cert: requiring client certificates needs tls-client-ca"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			if tt.file != "" {
+				env = map[string]string{"BH_CONFIG": writeFile(t, tt.file)}
+			}
+			_, err := load(t, env)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+
+	// Every invalid option is reported at once.
+	_, err := load(t, nil, "-registration-mode", "friends", "-query-timeout", "-1s", "-addr", "")
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("expected three errors, got %v", err)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.DB = "postgres://user:hunter2@db:5432/bashhub"
+	cfg.JWTSecret = "very-secret-signing-key"
+	cfg.Admins = []string{"alice"}
+	cfg.LegacyTokenLifetime = 10000 * time.Hour
+
+	var out bytes.Buffer
+	if err := cfg.Print(&out, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), "hunter2") || strings.Contains(out.String(), "very-secret") {
+		t.Errorf("expected secrets to be hidden, got %s", out.String())
+	}
+	if !strings.Contains(out.String(), `"jwt-secret": "REDACTED"`) || !strings.Contains(out.String(), `"jwt-previous-secret": ""`) {
+		t.Errorf("expected set secrets to be redacted and unset ones empty, got %s", out.String())
+	}
+
+	// The output is a config file that loads back to the same configuration.
+	out.Reset()
+	cfg.Print(&out, true)
+	var values map[string]any
+	if err := json.Unmarshal(out.Bytes(), &values); err != nil {
+		t.Fatalf("expected JSON, got %v: %s", err, out.String())
+	}
+	var loaded Config
+	if err := loaded.LoadFile(writeFile(t, out.String())); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded.DB != cfg.DB || loaded.JWTSecret != cfg.JWTSecret || loaded.LegacyTokenLifetime != cfg.LegacyTokenLifetime || len(loaded.Admins) != 1 {
+		t.Errorf("expected %+v, got %+v", cfg, loaded)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := map[string]string{
+		"postgres://user:hunter2@db/bashhub":         "postgres://user:xxxxx@db/bashhub",
+		"host=db user=bh password=hunter2 dbname=bh": "host=db user=bh password=xxxxx dbname=bh",
+		"host=db password = 'hunter 2' dbname=bh":    "host=db password = xxxxx dbname=bh",
+		"/var/lib/bashhub/data.db":                   "/var/lib/bashhub/data.db",
+	}
+	for value, want := range tests {
+		if got := redactURL(value); got != want {
+			t.Errorf("redactURL(%q): expected %q, got %q", value, want, got)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := map[string][]string{
+		"":             nil,
+		"alice":        {"alice"},
+		"alice, bob,,": {"alice", "bob"},
+	}
+	for value, want := range tests {
+		got := SplitList(value)
+		if len(got) != len(want) {
+			t.Errorf("SplitList(%q) = %q, want %q", value, got, want)
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("SplitList(%q) = %q, want %q", value, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUserAdministration(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		admin := createTestUser(t, store, "administrator")
+		doomed := createTestUser(t, store, "doomed")
+
+		before, err := store.UserCount(ctx)
+		if err != nil || before < 2 {
+			t.Fatalf("expected at least 2 users, got %d (%v)", before, err)
+		}
+		if updated, err := store.UserSetAdmin(ctx, admin.ID, true); err != nil || updated != 1 {
+			t.Fatalf("expected 1 updated user, got %d (%v)", updated, err)
+		}
+		if updated, err := store.UserSetDisabled(ctx, doomed.ID, true); err != nil || updated != 1 {
+			t.Fatalf("expected 1 updated user, got %d (%v)", updated, err)
+		}
+		if updated, _ := store.UserSetAdmin(ctx, doomed.ID+1000, true); updated != 0 {
+			t.Errorf("expected unknown user not to be updated, got %d", updated)
+		}
+
+		users, err := store.UserList(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		found := 0
+		for _, u := range users {
+			if u.Password != "" {
+				t.Errorf("expected list without password hashes, got %+v", u)
+			}
+			switch u.ID {
+			case admin.ID:
+				found++
+				if !u.IsAdmin || u.Disabled {
+					t.Errorf("unexpected admin %+v", u)
+				}
+			case doomed.ID:
+				found++
+				if u.IsAdmin || !u.Disabled {
+					t.Errorf("unexpected disabled user %+v", u)
+				}
+			}
+		}
+		if found != 2 {
+			t.Errorf("expected both users to be listed, got %+v", users)
+		}
+		if got, _ := store.UserGetByID(ctx, admin.ID); !got.IsAdmin {
+			t.Errorf("expected UserGetByID to report admin, got %+v", got)
+		}
+
+		now := time.Now().Unix()
+		mac := "00:11:22:33:44:55"
+		store.SystemInsert(ctx, System{Mac: mac, Name: stringPtrModel("laptop"), User: doomed})
+		store.CommandInsert(ctx, Command{Uuid: "doomed-command", Command: "rm -rf", Path: "/", Created: 1, User: doomed})
+		store.SessionCreate(ctx, Session{ID: "doomed-session", UserID: doomed.ID, Created: now, Expires: now + 60})
+		store.RefreshTokenCreate(ctx, RefreshToken{Hash: "doomed-refresh", SessionID: "doomed-session", Created: now, Expires: now + 60})
+		store.APIKeyCreate(ctx, APIKey{ID: "doomed-key", UserID: doomed.ID, Hash: "doomed-key", Scope: ScopeRead})
+		store.PasswordResetCreate(ctx, PasswordReset{Hash: "doomed-reset", UserID: doomed.ID, Created: now, Expires: now + 60})
+
+		if deleted, err := store.UserDelete(ctx, doomed.ID); err != nil || deleted != 1 {
+			t.Fatalf("expected 1 deleted user, got %d (%v)", deleted, err)
+		}
+		if deleted, _ := store.UserDelete(ctx, doomed.ID); deleted != 0 {
+			t.Errorf("expected deleting twice to do nothing, got %d", deleted)
+		}
+		if _, err := store.UserGetByID(ctx, doomed.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected deleted user to be gone, got %v", err)
+		}
+		if _, err := store.CommandGetUUID(ctx, Command{Uuid: "doomed-command", User: doomed}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected commands to be deleted, got %v", err)
+		}
+		if _, err := store.SystemGet(ctx, System{Mac: mac, User: doomed}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected systems to be deleted, got %v", err)
+		}
+		if _, err := store.SessionGet(ctx, "doomed-session"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected sessions to be deleted, got %v", err)
+		}
+		if _, err := store.APIKeyGetByHash(ctx, "doomed-key"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected api keys to be deleted, got %v", err)
+		}
+		if after, _ := store.UserCount(ctx); after != before-1 {
+			t.Errorf("expected %d users, got %d", before-1, after)
+		}
+	})
+}
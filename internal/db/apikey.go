@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+const apiKeyColumns = `"id", "user_id", "name", "hash", "scope", "created", "expires", "last_used"`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var key APIKey
+	var expires, lastUsed sql.NullInt64
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Hash, &key.Scope, &key.Created, &expires, &lastUsed)
+	if err != nil {
+		return APIKey{}, err
+	}
+	key.Expires = expires.Int64
+	key.LastUsed = lastUsed.Int64
+	return key, nil
+}
+
+func (s *SQLStore) APIKeyCreate(ctx context.Context, key APIKey) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO api_keys (`+apiKeyColumns+`)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID, key.UserID, key.Name, key.Hash, key.Scope, key.Created, key.Expires, key.LastUsed)
+	return wrapError("create api key", err)
+}
+
+// APIKeyGet returns one of the user's keys or ErrNotFound.
+func (s *SQLStore) APIKeyGet(ctx context.Context, userID uint, id string) (APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, `
+	SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE "user_id" = $1 AND "id" = $2`, userID, id))
+	return key, wrapError("get api key", err)
+}
+
+// APIKeyGetByHash returns the key with the given hash, expired or not, or
+// ErrNotFound.
+func (s *SQLStore) APIKeyGetByHash(ctx context.Context, hash string) (APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, `
+	SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE "hash" = $1`, hash))
+	return key, wrapError("get api key", err)
+}
+
+// APIKeyList returns all of the user's keys, newest first.
+func (s *SQLStore) APIKeyList(ctx context.Context, userID uint) ([]APIKey, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE "user_id" = $1
+		ORDER BY "created" DESC`, userID)
+	if err != nil {
+		return nil, wrapError("list api keys", err)
+	}
+	defer rows.Close()
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, wrapError("list api keys", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, wrapError("list api keys", rows.Err())
+}
+
+// APIKeyUpdate changes the name and scope of one of the user's keys.
+func (s *SQLStore) APIKeyUpdate(ctx context.Context, key APIKey) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE api_keys SET "name" = $3, "scope" = $4
+		WHERE "user_id" = $1 AND "id" = $2`, key.UserID, key.ID, key.Name, key.Scope)
+	if err != nil {
+		return 0, wrapError("update api key", err)
+	}
+	return affected("update api key", res)
+}
+
+func (s *SQLStore) APIKeyTouch(ctx context.Context, id string, lastUsed int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `UPDATE api_keys SET "last_used" = $2 WHERE "id" = $1`, id, lastUsed)
+	return wrapError("touch api key", err)
+}
+
+func (s *SQLStore) APIKeyDelete(ctx context.Context, userID uint, id string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE "user_id" = $1 AND "id" = $2`, userID, id)
+	if err != nil {
+		return 0, wrapError("delete api key", err)
+	}
+	return affected("delete api key", res)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := createTestUser(t, store, "apikeys")
+		other := createTestUser(t, store, "apikeys-other")
+		keys := []APIKey{
+			{ID: "key-1", UserID: user.ID, Name: "runbook", Hash: "hash-1", Scope: ScopeRead, Created: 100},
+			{ID: "key-2", UserID: user.ID, Name: "ci", Hash: "hash-2", Scope: ScopeReadWrite, Created: 200, Expires: 5000},
+			{ID: "key-3", UserID: other.ID, Name: "other", Hash: "hash-3", Scope: ScopeRead, Created: 300},
+		}
+		for _, key := range keys {
+			if err := store.APIKeyCreate(ctx, key); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if err := store.APIKeyCreate(ctx, APIKey{ID: "key-4", UserID: user.ID, Hash: "hash-1", Scope: ScopeRead}); err == nil {
+			t.Errorf("expected error for duplicate hash, got nil")
+		}
+
+		got, err := store.APIKeyGetByHash(ctx, "hash-2")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != keys[1] {
+			t.Errorf("expected %+v, got %+v", keys[1], got)
+		}
+		if _, err := store.APIKeyGetByHash(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v, got %v", ErrNotFound, err)
+		}
+		if _, err := store.APIKeyGet(ctx, other.ID, "key-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected other user's lookup to fail with %v, got %v", ErrNotFound, err)
+		}
+
+		list, err := store.APIKeyList(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) != 2 || list[0].ID != "key-2" || list[1].ID != "key-1" {
+			t.Errorf("expected user's keys newest first, got %+v", list)
+		}
+
+		if err := store.APIKeyTouch(ctx, "key-1", 4242); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		updated, err := store.APIKeyUpdate(ctx, APIKey{ID: "key-1", UserID: user.ID, Name: "renamed", Scope: ScopeReadWrite})
+		if err != nil || updated != 1 {
+			t.Fatalf("expected 1 updated key, got %d (%v)", updated, err)
+		}
+		if updated, _ := store.APIKeyUpdate(ctx, APIKey{ID: "key-1", UserID: other.ID, Name: "stolen"}); updated != 0 {
+			t.Errorf("expected other user's update to be ignored, got %d", updated)
+		}
+		got, _ = store.APIKeyGet(ctx, user.ID, "key-1")
+		if got.Name != "renamed" || got.Scope != ScopeReadWrite || got.LastUsed != 4242 || got.Hash != "hash-1" {
+			t.Errorf("unexpected key after update %+v", got)
+		}
+
+		if deleted, _ := store.APIKeyDelete(ctx, other.ID, "key-1"); deleted != 0 {
+			t.Errorf("expected other user's delete to be ignored, got %d", deleted)
+		}
+		if deleted, _ := store.APIKeyDelete(ctx, user.ID, "key-1"); deleted != 1 {
+			t.Errorf("expected 1 deleted key, got %d", deleted)
+		}
+		if _, err := store.APIKeyGetByHash(ctx, "hash-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected deleted key to be gone, got %v", err)
+		}
+	})
+}
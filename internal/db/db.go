@@ -1,487 +1,671 @@
 package db
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
 )
 
-const (
-	errCheckingRowExists = "error checking if row exists %v"
-)
-
-var (
+// SQLStore is the Store implementation backed by database/sql, speaking
+// either the Postgres or the SQLite dialect depending on the -db value.
+type SQLStore struct {
 	db              *sql.DB
 	connectionLimit int
+	dialect         string
+	queryTimeout    time.Duration
+	passwords       passwordHasher
+	// replica serves command searches when a read replica is configured.
+	replica *sql.DB
+}
+
+// Options configures how a database is opened.
+type Options struct {
+	// SkipMigrations leaves pending migrations unapplied; opening then fails
+	// with ErrSchemaOutdated unless the schema is already current.
+	SkipMigrations bool
+	// AllowOutdated lets a store opened with SkipMigrations be returned even
+	// though migrations are pending, for tools that apply them themselves.
+	AllowOutdated bool
+	// QueryTimeout bounds every store call; zero means calls are only
+	// limited by the context passed in. Migrations and maintenance commands
+	// are not bounded.
+	QueryTimeout time.Duration
+
+	// MaxOpenConns caps the connections to Postgres, 50 when zero. SQLite
+	// always uses a single connection.
+	MaxOpenConns int
+	// MaxIdleConns is how many idle connections are kept for reuse; zero
+	// keeps the database/sql default of 2.
+	MaxIdleConns int
+	// ConnMaxLifetime and ConnMaxIdleTime close connections once they are
+	// that old or have been idle that long; zero keeps them open.
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout is how long opening keeps retrying, with backoff, a
+	// database that is not reachable yet. Zero tries once.
+	ConnectTimeout time.Duration
+	// ReadReplica is the URL of a replica of the same kind of database that
+	// command searches are sent to. Everything else uses the primary.
+	ReadReplica string
+	// Logf, if set, reports connection retries.
+	Logf func(format string, v ...any)
+	// PasswordIterations is the PBKDF2 work factor for new password hashes,
+	// 600000 when zero. Tests lower it to keep hashing fast.
+	PasswordIterations int
+}
+
+// connectBackoff is the first wait between connection attempts. It doubles
+// after each failed attempt up to maxConnectBackoff.
+var (
+	connectBackoff    = 500 * time.Millisecond
+	maxConnectBackoff = 10 * time.Second
 )
 
-func Init(dbPath string) error {
-	var err error
-	db, err = sql.Open("postgres", dbPath)
+// Open returns the Store named by dbPath: memory:// keeps data in process
+// memory, a postgres:// URL or key=value connection string connects to
+// Postgres and anything else is opened as a SQLite database file.
+func Open(dbPath string, opts Options) (Store, error) {
+	dialect, err := dialectOf(dbPath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	if err = db.Ping(); err != nil {
-		log.Fatal(err)
+	if dialect == dialectMemory {
+		store := NewMemoryStore()
+		store.passwords = passwordHasher{iterations: opts.PasswordIterations}
+		return store, nil
 	}
-	connectionLimit = 50
-	db.SetMaxOpenConns(connectionLimit)
-	createTables()
-	createIndexes()
-	return nil
+	return NewSQLStore(dbPath, opts)
 }
 
-func createTables() {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			username VARCHAR(200) UNIQUE NOT NULL,
-			email VARCHAR(255),
-			password VARCHAR(255),
-			registration_code VARCHAR(255)
-		)`,
-		`CREATE TABLE IF NOT EXISTS systems (
-			id SERIAL PRIMARY KEY,
-			name VARCHAR(255),
-			mac VARCHAR(255),
-			user_id INTEGER REFERENCES users(id),
-			hostname VARCHAR(255),
-			client_version VARCHAR(255),
-			created BIGINT,
-			updated BIGINT
-		)`,
-		`CREATE TABLE IF NOT EXISTS commands (
-			id SERIAL PRIMARY KEY,
-			command TEXT,
-			path VARCHAR(255),
-			created BIGINT,
-			uuid VARCHAR(255) UNIQUE NOT NULL,
-			exit_status INTEGER,
-			system_name VARCHAR(255),
-			process_id INTEGER,
-			process_start_time BIGINT,
-			user_id INTEGER REFERENCES users(id),
-			session_id VARCHAR(255)
-		)`,
-		`CREATE TABLE IF NOT EXISTS configs (
-			id SERIAL PRIMARY KEY,
-			created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			secret VARCHAR(255)
-		)`,
+// NewSQLStore connects to Postgres or SQLite and brings the schema up to date.
+func NewSQLStore(dbPath string, opts Options) (*SQLStore, error) {
+	ctx := context.Background()
+	dialect, err := dialectOf(dbPath)
+	if err != nil {
+		return nil, err
 	}
-
-	for _, query := range queries {
-		_, err := db.Exec(query)
-		if err != nil {
-			log.Fatalf("Failed to create table: %v", err)
-		}
+	if dialect == dialectMemory {
+		return nil, fmt.Errorf("db %q is not a SQL database", dbPath)
 	}
-}
-
-func createIndexes() {
-	indexes := []string{
-		"CREATE UNIQUE INDEX IF NOT EXISTS idx_user ON users(username)",
-		"CREATE INDEX IF NOT EXISTS idx_mac ON systems(mac)",
-		"CREATE INDEX IF NOT EXISTS idx_user_command_created ON commands(user_id, created, command)",
-		"CREATE INDEX IF NOT EXISTS idx_user_uuid ON commands(user_id, uuid)",
-		"CREATE UNIQUE INDEX IF NOT EXISTS idx_uuid ON commands(uuid)",
+	s := &SQLStore{dialect: dialect, queryTimeout: opts.QueryTimeout, passwords: passwordHasher{iterations: opts.PasswordIterations}}
+	if s.db, s.connectionLimit, err = openDB(ctx, dialect, dbPath, opts); err != nil {
+		return nil, err
 	}
-
-	for _, index := range indexes {
-		_, err := db.Exec(index)
-		if err != nil {
-			log.Fatalf("Failed to create index: %v", err)
+	if opts.ReadReplica != "" {
+		if replicaDialect, err := dialectOf(opts.ReadReplica); err != nil || replicaDialect != s.dialect {
+			s.db.Close()
+			return nil, fmt.Errorf("read replica must be a %s database", s.dialect)
+		}
+		if s.replica, _, err = openDB(ctx, dialect, opts.ReadReplica, opts); err != nil {
+			s.db.Close()
+			return nil, fmt.Errorf("read replica: %w", err)
 		}
 	}
+	if err = s.prepareSchema(ctx, opts); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
 }
-func GetSecret() (string, error) {
-	var err error
-	var secret string
-	if connectionLimit != 1 {
-		_, err = db.Exec(`INSERT INTO configs ("id","created", "secret") 
-						VALUES (1, now(), (SELECT md5(random()::text)))
-						ON conflict do nothing;`)
+
+// openDB opens the dialect database at dbPath with the pool settings of opts
+// and waits for it to answer. It returns the connection limit applied.
+func openDB(ctx context.Context, dialect, dbPath string, opts Options) (*sql.DB, int, error) {
+	var (
+		db    *sql.DB
+		err   error
+		limit int
+	)
+	if dialect == dialectSQLite {
+		db, err = sql.Open("sqlite", sqliteDSN(dbPath))
+		limit = 1
 	} else {
-		_, err = db.Exec(`INSERT INTO configs ("id","created" ,"secret") 
-						VALUES (1, current_timestamp, lower(hex(randomblob(16)))) 
-						ON conflict do nothing;`)
+		db, err = sql.Open("postgres", dbPath)
+		limit = 50
+		if opts.MaxOpenConns > 0 {
+			limit = opts.MaxOpenConns
+		}
 	}
 	if err != nil {
-		log.Fatal(err)
+		return nil, 0, err
 	}
-	err = db.QueryRow(`SELECT "secret" from configs where "id" = 1 `).Scan(&secret)
-	if err != nil {
-		log.Fatal(err)
+	db.SetMaxOpenConns(limit)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
 	}
-	return secret, nil
-}
-func HashAndSalt(password string) string {
-	salt := make([]byte, 16)
-	if _, err := rand.Read(salt); err != nil {
-		log.Println("Failed to generate salt:", err)
-		return ""
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+	if err = ping(ctx, db, opts); err != nil {
+		db.Close()
+		return nil, 0, err
 	}
-
-	hash := sha256.Sum256(append([]byte(password), salt...))
-	result := append(salt, hash[:]...)
-	return hex.EncodeToString(result)
+	return db, limit, nil
 }
 
-func ComparePasswords(hashedPwd string, plainPwd string) error {
-	data, err := hex.DecodeString(hashedPwd)
-	if err != nil {
-		return err
+// ping waits for db to answer. While the database is unavailable, e.g. still
+// starting next to the server, it is retried with exponential backoff until
+// opts.ConnectTimeout has passed. Other failures are returned at once.
+func ping(ctx context.Context, db *sql.DB, opts Options) error {
+	deadline := time.Now().Add(opts.ConnectTimeout)
+	wait := connectBackoff
+	for attempt := 1; ; attempt++ {
+		err := wrapError("connect", db.PingContext(ctx))
+		if err == nil || !errors.Is(err, ErrUnavailable) || time.Now().Add(wait).After(deadline) {
+			return err
+		}
+		if opts.Logf != nil {
+			opts.Logf("database not ready after attempt %d, retrying in %v: %v", attempt, wait, err)
+		}
+		time.Sleep(wait)
+		wait = min(2*wait, maxConnectBackoff)
 	}
+}
 
-	if len(data) < 32 {
-		return fmt.Errorf("invalid hash format")
+func (s *SQLStore) Close() error {
+	if s.replica != nil {
+		s.replica.Close()
 	}
+	return s.db.Close()
+}
 
-	salt := data[:16]
-	storedHash := data[16:]
+// reader returns the database command searches are sent to: the read
+// replica if one is configured, otherwise the primary.
+func (s *SQLStore) reader() *sql.DB {
+	if s.replica != nil {
+		return s.replica
+	}
+	return s.db
+}
 
-	hash := sha256.Sum256(append([]byte(plainPwd), salt...))
+// PoolStats returns the connection pool statistics of the primary database
+// and, when one is configured, the read replica, keyed primary and replica.
+func (s *SQLStore) PoolStats() map[string]sql.DBStats {
+	stats := map[string]sql.DBStats{"primary": s.db.Stats()}
+	if s.replica != nil {
+		stats["replica"] = s.replica.Stats()
+	}
+	return stats
+}
 
-	if !equalHashes(hash[:], storedHash) {
-		return fmt.Errorf("password mismatch")
+// withTimeout derives the context of a single store call, bounded by
+// Options.QueryTimeout. Cancelling it aborts the statement on the server.
+func (s *SQLStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
 
+// prepareSchema refuses databases migrated by a newer release and applies
+// pending migrations unless opts says otherwise.
+func (s *SQLStore) prepareSchema(ctx context.Context, opts Options) error {
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil || pending == 0 {
+		return err
+	}
+	if !opts.SkipMigrations {
+		_, err = s.Migrate(ctx)
+		return err
+	}
+	if !opts.AllowOutdated {
+		return ErrSchemaOutdated
+	}
 	return nil
 }
 
-func equalHashes(a, b []byte) bool {
-	if len(a) != len(b) {
-		return false
+func (s *SQLStore) GetSecret(ctx context.Context) (string, error) {
+	secrets, err := s.GetSecrets(ctx)
+	if err != nil {
+		return "", wrapError("get secret", err)
 	}
-	var result byte
-	for i := range a {
-		result |= a[i] ^ b[i]
+	return secrets[0].Secret, nil
+}
+
+// GetSecrets returns the current JWT signing secret followed by the one it
+// replaced, if any. The first secret is generated on first use.
+func (s *SQLStore) GetSecrets(ctx context.Context) ([]Secret, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	initial, err := newSecret()
+	if err != nil {
+		return nil, wrapError("get secrets", err)
 	}
-	return result == 0
+	_, err = s.db.ExecContext(ctx, `INSERT INTO configs ("id", "created", "secret")
+						VALUES (1, $1, $2)
+						ON conflict do nothing`, initial.Created, initial.Secret)
+	if err != nil {
+		return nil, wrapError("get secrets", err)
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT "secret", "created" FROM configs ORDER BY "id" DESC LIMIT 2`)
+	if err != nil {
+		return nil, wrapError("get secrets", err)
+	}
+	defer rows.Close()
+	var secrets []Secret
+	for rows.Next() {
+		var secret Secret
+		if err = rows.Scan(&secret.Secret, &secret.Created); err != nil {
+			return nil, wrapError("get secrets", err)
+		}
+		secrets = append(secrets, secret)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapError("get secrets", err)
+	}
+	return secrets, nil
 }
-func (user User) UserExists() error {
+
+// RotateSecret stores a freshly generated signing secret which becomes the
+// current one; the previous secret is still returned by GetSecrets.
+func (s *SQLStore) RotateSecret(ctx context.Context) (Secret, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	secret, err := newSecret()
+	if err != nil {
+		return Secret{}, wrapError("rotate secret", err)
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO configs ("id", "created", "secret")
+						VALUES ((SELECT COALESCE(MAX("id"), 0) + 1 FROM configs), $1, $2)`,
+		secret.Created, secret.Secret)
+	if err != nil {
+		return Secret{}, wrapError("rotate secret", err)
+	}
+	return secret, nil
+}
+
+func newSecret() (Secret, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Secret{}, err
+	}
+	return Secret{Secret: hex.EncodeToString(b), Created: time.Now().UTC()}, nil
+}
+
+func (s *SQLStore) UserExists(ctx context.Context, user User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var password string
-	err := db.QueryRow("SELECT password FROM users WHERE username = $1",
+	err := s.db.QueryRowContext(ctx, "SELECT password FROM users WHERE username = $1",
 		user.Username).Scan(&password)
-	if err != nil && err != sql.ErrNoRows {
-		log.Fatalf(errCheckingRowExists, err)
+	if err != nil {
+		return wrapError("check credentials", err)
+	}
+	// A wrong password is reported like an unknown user.
+	if err := ComparePasswords(password, user.Password); err != nil {
+		return notFound("check credentials")
 	}
-	if password != "" {
-		return ComparePasswords(password, user.Password)
+	if s.passwords.needsRehash(password) {
+		// Upgrade legacy or weaker hashes now that the plain password is known.
+		// A failure leaves the old hash in place and is retried next login.
+		s.db.ExecContext(ctx, `UPDATE users SET "password" = $1 WHERE "username" = $2 AND "password" = $3`,
+			s.passwords.hash(user.Password), user.Username, password)
 	}
 	return nil
 }
-func (user User) UserGetID() (uint, error) {
+func (s *SQLStore) UserGetID(ctx context.Context, user User) (uint, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var id uint
-	err := db.QueryRow(`SELECT "id" 
+	err := s.db.QueryRowContext(ctx, `SELECT "id" 
 							FROM users 
 							WHERE "username"  = $1`,
 		user.Username).Scan(&id)
-	if err != nil && err != sql.ErrNoRows {
-		log.Fatalf(errCheckingRowExists, err)
+	if err != nil {
+		return 0, wrapError("get user id", err)
 	}
 	return id, nil
 }
-func (user User) UserGetSystemName() (string, error) {
+
+// UserGetByID returns the user with the given id, without its password hash,
+// or ErrNotFound.
+func (s *SQLStore) UserGetByID(ctx context.Context, id uint) (User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var user User
+	err := s.db.QueryRowContext(ctx, `SELECT "id", "username", "email", "is_admin", "disabled"
+							FROM users
+							WHERE "id" = $1`,
+		id).Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.Disabled)
+	if err != nil {
+		return User{}, wrapError("get user", err)
+	}
+	return user, nil
+}
+
+func (s *SQLStore) UserGetSystemName(ctx context.Context, user User) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var systemName string
-	err := db.QueryRow(`SELECT name 
+	err := s.db.QueryRowContext(ctx, `SELECT name 
 							FROM systems 
 							WHERE user_id in (select id from users where username = $1)
 							AND mac = $2`,
 		user.Username, user.Mac).Scan(&systemName)
-	if err != nil && err != sql.ErrNoRows {
-		log.Fatalf(errCheckingRowExists, err)
+	// Users who have not registered this system yet have no system name.
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", wrapError("get system name", err)
 	}
 	return systemName, nil
 }
-func (user User) UsernameExists() (bool, error) {
+func (s *SQLStore) UsernameExists(ctx context.Context, user User) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var exists bool
-	err := db.QueryRow(`SELECT exists (select id FROM users WHERE "username" = $1)`,
+	err := s.db.QueryRowContext(ctx, `SELECT exists (select id FROM users WHERE "username" = $1)`,
 		user.Username).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		log.Fatalf(errCheckingRowExists, err)
+	if err != nil {
+		return false, wrapError("check username", err)
 	}
 	return exists, nil
 }
-func (user User) EmailExists() (bool, error) {
+func (s *SQLStore) EmailExists(ctx context.Context, user User) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var exists bool
-	err := db.QueryRow(`SELECT exists (select id FROM users WHERE "email" = $1)`,
+	err := s.db.QueryRowContext(ctx, `SELECT exists (select id FROM users WHERE "email" = $1)`,
 		user.Email).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		log.Fatalf(errCheckingRowExists, err)
+	if err != nil {
+		return false, wrapError("check email", err)
 	}
 	return exists, nil
 }
-func (user User) UserCreate() (int64, error) {
-	user.Password = HashAndSalt(user.Password)
-	res, err := db.Exec(`INSERT INTO users("registration_code", "username","password","email")
+func (s *SQLStore) UserCreate(ctx context.Context, user User) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	user.Password = s.passwords.hash(user.Password)
+	res, err := s.db.ExecContext(ctx, `INSERT INTO users("registration_code", "username","password","email")
  							 VALUES ($1,$2,$3,$4) ON CONFLICT(username) do nothing`, user.RegistrationCode,
 		user.Username, user.Password, user.Email)
 	if err != nil {
-		log.Fatal(err)
+		return 0, wrapError("create user", err)
+	}
+	return affected("create user", res)
+}
+
+// UserSetPassword replaces the password of the user with the given id.
+func (s *SQLStore) UserSetPassword(ctx context.Context, id uint, password string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET "password" = $1 WHERE "id" = $2`, s.passwords.hash(password), id)
+	if err != nil {
+		return 0, wrapError("set password", err)
 	}
-	inserted, err := res.RowsAffected()
+	return affected("set password", res)
+}
+
+// UserList returns every user, without password hashes, in id order.
+func (s *SQLStore) UserList(ctx context.Context) ([]User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT "id", "username", "email", "is_admin", "disabled"
+							FROM users
+							ORDER BY "id"`)
+	if err != nil {
+		return nil, wrapError("list users", err)
+	}
+	defer rows.Close()
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.IsAdmin, &user.Disabled); err != nil {
+			return nil, wrapError("list users", err)
+		}
+		users = append(users, user)
+	}
+	return users, wrapError("list users", rows.Err())
+}
+
+func (s *SQLStore) UserCount(ctx context.Context) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, wrapError("count users", err)
+}
+
+func (s *SQLStore) UserSetAdmin(ctx context.Context, id uint, admin bool) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET "is_admin" = $1 WHERE "id" = $2`, admin, id)
+	if err != nil {
+		return 0, wrapError("set admin", err)
+	}
+	return affected("set admin", res)
+}
+
+func (s *SQLStore) UserSetDisabled(ctx context.Context, id uint, disabled bool) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET "disabled" = $1 WHERE "id" = $2`, disabled, id)
 	if err != nil {
-		log.Fatal(err)
+		return 0, wrapError("set disabled", err)
 	}
-	return inserted, nil
+	return affected("set disabled", res)
 }
-func (cmd Command) CommandInsert() (int64, error) {
-	res, err := db.Exec(`
+
+// UserDelete removes a user together with their history, systems and
+// credentials. Invites they minted are kept without an owner.
+func (s *SQLStore) UserDelete(ctx context.Context, id uint) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, wrapError("delete user", err)
+	}
+	defer tx.Rollback()
+	queries := []string{
+		`DELETE FROM refresh_tokens WHERE "session_id" IN (SELECT "id" FROM sessions WHERE "user_id" = $1)`,
+		`DELETE FROM sessions WHERE "user_id" = $1`,
+		`DELETE FROM api_keys WHERE "user_id" = $1`,
+		`DELETE FROM password_resets WHERE "user_id" = $1`,
+		`UPDATE invites SET "created_by" = NULL WHERE "created_by" = $1`,
+		`DELETE FROM commands WHERE "user_id" = $1`,
+		`DELETE FROM systems WHERE "user_id" = $1`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return 0, wrapError("delete user", err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE "id" = $1`, id)
+	if err != nil {
+		return 0, wrapError("delete user", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError("delete user", err)
+	}
+	return deleted, wrapError("delete user", tx.Commit())
+}
+
+func (s *SQLStore) CommandInsert(ctx context.Context, cmd Command) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `
 	INSERT INTO commands("process_id","process_start_time","exit_status","uuid","command", "created", "path", "user_id", "system_name")
  	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) ON CONFLICT do nothing`,
 		cmd.ProcessId, cmd.ProcessStartTime, cmd.ExitStatus, cmd.Uuid, cmd.Command, cmd.Created, cmd.Path, cmd.User.ID, cmd.SystemName)
 	if err != nil {
-		log.Fatal(err)
-	}
-	inserted, err := res.RowsAffected()
-	if err != nil {
-		log.Fatal(err)
+		return 0, wrapError("insert command", err)
 	}
-	return inserted, nil
+	return affected("insert command", res)
 }
-func (cmd Command) CommandGet() ([]Query, error) {
+func (s *SQLStore) CommandGet(ctx context.Context, cmd Command) ([]Query, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var (
 		results []Query
 	)
-	var query string
-	var args []interface{}
+	args := []interface{}{cmd.User.ID}
+	conditions := []string{`"user_id" = $1`}
+	if cmd.Path != "" {
+		args = append(args, cmd.Path)
+		conditions = append(conditions, fmt.Sprintf(`"path" = $%d`, len(args)))
+	}
+	if cmd.SystemName != "" {
+		args = append(args, cmd.SystemName)
+		conditions = append(conditions, fmt.Sprintf(`"system_name" = $%d`, len(args)))
+	}
 	if cmd.Query != "" {
-		if cmd.Unique {
-			if cmd.Path != "" && cmd.SystemName != "" {
-				query = `
-					SELECT DISTINCT ON ("command") command, "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "path" = $2 AND "system_name" = $3 AND "command" ~ $4
-					ORDER BY "command", "created" DESC LIMIT $5`
-				args = []interface{}{cmd.User.ID, cmd.Path, cmd.SystemName, cmd.Query, cmd.Limit}
-			} else if cmd.Path != "" {
-				query = `
-					SELECT DISTINCT ON ("command") command, "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "path" = $2 AND "command" ~ $3
-					ORDER BY "command", "created" DESC LIMIT $4`
-				args = []interface{}{cmd.User.ID, cmd.Path, cmd.Query, cmd.Limit}
-			} else if cmd.SystemName != "" {
-				query = `
-					SELECT DISTINCT ON ("command") command, "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "system_name" = $2 AND "command" ~ $3
-					ORDER BY "command", "created" DESC LIMIT $4`
-				args = []interface{}{cmd.User.ID, cmd.SystemName, cmd.Query, cmd.Limit}
-			} else {
-				query = `
-					SELECT DISTINCT ON ("command") command, "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "command" ~ $2
-					ORDER BY "command", "created" DESC LIMIT $3`
-				args = []interface{}{cmd.User.ID, cmd.Query, cmd.Limit}
-			}
-		} else {
-			if cmd.Path != "" && cmd.SystemName != "" {
-				query = `
-					SELECT "command", "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "path" = $2 AND "system_name" = $3 AND "command" ~ $4
-					ORDER BY "created" DESC LIMIT $5`
-				args = []interface{}{cmd.User.ID, cmd.Path, cmd.SystemName, cmd.Query, cmd.Limit}
-			} else if cmd.Path != "" {
-				query = `
-					SELECT "command", "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "path" = $2 AND "command" ~ $3
-					ORDER BY "created" DESC LIMIT $4`
-				args = []interface{}{cmd.User.ID, cmd.Path, cmd.Query, cmd.Limit}
-			} else if cmd.SystemName != "" {
-				query = `
-					SELECT "command", "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "system_name" = $2 AND "command" ~ $3
-					ORDER BY "created" DESC LIMIT $4`
-				args = []interface{}{cmd.User.ID, cmd.SystemName, cmd.Query, cmd.Limit}
-			} else {
-				query = `
-					SELECT "command", "uuid", "created"
-					FROM commands
-					WHERE "user_id" = $1 AND "command" ~ $2
-					ORDER BY "created" DESC LIMIT $3`
-				args = []interface{}{cmd.User.ID, cmd.Query, cmd.Limit}
+		regexOp := "~"
+		if s.dialect == dialectSQLite {
+			regexOp = "REGEXP"
+			// Errors raised inside the REGEXP function reach us as generic
+			// SQLite errors, so reject bad patterns up front.
+			if _, err := compileRegexp(cmd.Query); err != nil {
+				return []Query{}, &Error{Op: "search commands", Kind: ErrInvalid, Err: err}
 			}
 		}
-	} else if cmd.Unique {
-		if cmd.Path != "" && cmd.SystemName != "" {
-			query = `
-				SELECT DISTINCT ON ("command") command, "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1 AND "path" = $2 AND "system_name" = $3
-				ORDER BY "command", "created" DESC LIMIT $4`
-			args = []interface{}{cmd.User.ID, cmd.Path, cmd.SystemName, cmd.Limit}
-		} else if cmd.Path != "" {
-			query = `
-				SELECT DISTINCT ON ("command") command, "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1 AND "path" = $2
-				ORDER BY "command", "created" DESC LIMIT $3`
-			args = []interface{}{cmd.User.ID, cmd.Path, cmd.Limit}
-		} else if cmd.SystemName != "" {
-			query = `
-				SELECT DISTINCT ON ("command") command, "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1 AND "system_name" = $2
-				ORDER BY "command", "created" DESC LIMIT $3`
-			args = []interface{}{cmd.User.ID, cmd.SystemName, cmd.Limit}
-		} else {
-			query = `
-				SELECT DISTINCT ON ("command") command, "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1
-				ORDER BY "command", "created" DESC LIMIT $2`
-			args = []interface{}{cmd.User.ID, cmd.Limit}
-		}
-	} else {
-		if cmd.Path != "" && cmd.SystemName != "" {
-			query = `
-				SELECT "command", "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1 AND "path" = $2 AND "system_name" = $3
-				ORDER BY "created" DESC LIMIT $4`
-			args = []interface{}{cmd.User.ID, cmd.Path, cmd.SystemName, cmd.Limit}
-		} else if cmd.Path != "" {
-			query = `
-				SELECT "command", "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1 AND "path" = $2
-				ORDER BY "created" DESC LIMIT $3`
-			args = []interface{}{cmd.User.ID, cmd.Path, cmd.Limit}
-		} else if cmd.SystemName != "" {
-			query = `
-				SELECT "command", "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1 AND "system_name" = $2
-				ORDER BY "created" DESC LIMIT $3`
-			args = []interface{}{cmd.User.ID, cmd.SystemName, cmd.Limit}
-		} else {
-			query = `
-				SELECT "command", "uuid", "created"
-				FROM commands
-				WHERE "user_id" = $1
-				ORDER BY "created" DESC LIMIT $2`
-			args = []interface{}{cmd.User.ID, cmd.Limit}
-		}
+		args = append(args, cmd.Query)
+		conditions = append(conditions, fmt.Sprintf(`"command" %s $%d`, regexOp, len(args)))
 	}
-	rows, err := db.Query(query, args...)
+	args = append(args, cmd.Limit)
+	where := strings.Join(conditions, " AND ")
+
+	var query string
+	switch {
+	case cmd.Unique && s.dialect == dialectSQLite:
+		// SQLite has no DISTINCT ON; a bare column next to MAX() is taken
+		// from the row holding the maximum, i.e. the latest run of a command.
+		query = fmt.Sprintf(`
+			SELECT "command", "uuid", MAX("created")
+			FROM commands
+			WHERE %s
+			GROUP BY "command"
+			ORDER BY "command" LIMIT $%d`, where, len(args))
+	case cmd.Unique:
+		query = fmt.Sprintf(`
+			SELECT DISTINCT ON ("command") command, "uuid", "created"
+			FROM commands
+			WHERE %s
+			ORDER BY "command", "created" DESC LIMIT $%d`, where, len(args))
+	default:
+		query = fmt.Sprintf(`
+			SELECT "command", "uuid", "created"
+			FROM commands
+			WHERE %s
+			ORDER BY "created" DESC LIMIT $%d`, where, len(args))
+	}
+	rows, err := s.reader().QueryContext(ctx, query, args...)
 	if err != nil {
-		return []Query{}, err
+		return []Query{}, wrapError("search commands", err)
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var result Query
 		err = rows.Scan(&result.Command, &result.Uuid, &result.Created)
 		if err != nil {
-			return []Query{}, err
+			return []Query{}, wrapError("search commands", err)
 		}
 		results = append(results, result)
 	}
 	return results, nil
 }
-func (cmd Command) CommandGetUUID() (Query, error) {
+func (s *SQLStore) CommandGetUUID(ctx context.Context, cmd Command) (Query, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var result Query
-	err := db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
 	SELECT "command","path", "created" , "uuid", "exit_status", "system_name", "process_id" 
 		FROM commands
 		WHERE "uuid" = $1 
 	AND "user_id" = $2`, cmd.Uuid, cmd.User.ID).Scan(&result.Command, &result.Path, &result.Created, &result.Uuid,
 		&result.ExitStatus, &result.SystemName, &result.SessionID)
 	if err != nil {
-		return Query{}, err
+		return Query{}, wrapError("get command", err)
 	}
 	return result, nil
 }
-func (cmd Command) CommandDelete() (int64, error) {
-	res, err := db.Exec(`
+func (s *SQLStore) CommandDelete(ctx context.Context, cmd Command) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `
 	DELETE FROM commands WHERE "user_id" = $1 AND "uuid" = $2 `, cmd.User.ID, cmd.Uuid)
 	if err != nil {
-		log.Fatal(err)
-	}
-	inserted, err := res.RowsAffected()
-	if err != nil {
-		log.Fatal(err)
+		return 0, wrapError("delete command", err)
 	}
-	return inserted, nil
+	return affected("delete command", res)
 }
-func (sys System) SystemUpdate() (int64, error) {
+func (s *SQLStore) SystemUpdate(ctx context.Context, sys System) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	t := time.Now().Unix()
-	res, err := db.Exec(`
+	res, err := s.db.ExecContext(ctx, `
 	UPDATE systems 
 		SET "hostname" = $1 , "updated" = $2
 		WHERE "user_id" = $3
 		AND "mac" = $4`,
 		sys.Hostname, t, sys.User.ID, sys.Mac)
 	if err != nil {
-		log.Fatal(err)
-	}
-	inserted, err := res.RowsAffected()
-	if err != nil {
-		log.Fatal(err)
+		return 0, wrapError("update system", err)
 	}
-	return inserted, nil
+	return affected("update system", res)
 }
-func (sys System) SystemInsert() (int64, error) {
+func (s *SQLStore) SystemInsert(ctx context.Context, sys System) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	t := time.Now().Unix()
-	res, err := db.Exec(`INSERT INTO systems ("name", "mac", "user_id", "hostname", "client_version", "created", "updated")
+	res, err := s.db.ExecContext(ctx, `INSERT INTO systems ("name", "mac", "user_id", "hostname", "client_version", "created", "updated")
  									  VALUES ($1, $2, $3, $4, $5, $6, $7)`,
 		sys.Name, sys.Mac, sys.User.ID, sys.Hostname, sys.ClientVersion, t, t)
 	if err != nil {
-		log.Fatal(err)
-	}
-	inserted, err := res.RowsAffected()
-	if err != nil {
-		log.Fatal(err)
+		return 0, wrapError("insert system", err)
 	}
-	return inserted, nil
+	return affected("insert system", res)
 }
-func (sys System) SystemGet() (System, error) {
+func (s *SQLStore) SystemGet(ctx context.Context, sys System) (System, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 	var row System
-	err := db.QueryRow(`SELECT "name", "mac", "user_id", "hostname", "client_version",
+	err := s.db.QueryRowContext(ctx, `SELECT "name", "mac", "user_id", "hostname", "client_version",
  									  "id", "created", "updated" FROM systems 
  							  WHERE  "user_id" = $1
  							  AND "mac" = $2`,
 		sys.User.ID, sys.Mac).Scan(&row.Name, &row.Mac, &row.UserId, &row.Hostname,
 		&row.ClientVersion, &row.ID, &row.Created, &row.Updated)
 	if err != nil {
-		return System{}, err
+		return System{}, wrapError("get system", err)
 	}
 	return row, nil
 }
-func (status Status) StatusGet() (Status, error) {
-	err := db.QueryRow(`
+func (s *SQLStore) StatusGet(ctx context.Context, status Status) (Status, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	today := `to_timestamp(cast(created/1000 as bigint))::date = now()::date`
+	if s.dialect == dialectSQLite {
+		today = `date(created/1000, 'unixepoch') = date('now')`
+	}
+	err := s.db.QueryRowContext(ctx, `
 		select
       		( select count(*) from commands where user_id = $1) as totalCommands,
       		( select count(distinct process_id) from commands where user_id = $1) as totalSessions,
       		( select count(*) from systems where user_id = $1) as totalSystems,
-      		( select count(*) from commands where to_timestamp(cast(created/1000 as bigint))::date = now()::date and  user_id = $1) as totalCommandsToday,
+      		( select count(*) from commands where `+today+` and  user_id = $1) as totalCommandsToday,
       		( select count(*) from commands where process_id = $2) as sessionTotalCommands`,
 		status.User.ID, status.ProcessID).Scan(
 		&status.TotalCommands, &status.TotalSessions, &status.TotalSystems,
 		&status.TotalCommandsToday, &status.SessionTotalCommands)
 	if err != nil {
-		return Status{}, err
+		return Status{}, wrapError("get status", err)
 	}
-	return status, err
+	return status, wrapError("get status", err)
 }
-func ImportCommands(imp Import) error {
-	_, err := db.Exec(`
+func (s *SQLStore) ImportCommands(ctx context.Context, imp Import) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
 	INSERT INTO commands ("command", "path", "created", "uuid", "exit_status","system_name", "session_id", "user_id" )
 	VALUES ($1,$2,$3,$4,$5,$6,$7 ,(select "id" from users where "username" = $8)) ON CONFLICT do nothing`,
 		imp.Command, imp.Path, imp.Created, imp.Uuid, imp.ExitStatus, imp.SystemName, imp.SessionID, imp.Username)
 	if err != nil {
-		return err
+		return wrapError("import command", err)
 	}
 	return nil
 }
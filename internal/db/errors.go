@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The kinds of failure a Store reports. Store methods return an *Error whose
+// Kind is one of these, so callers can tell them apart with errors.Is.
+var (
+	// ErrNotFound means the requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means a row with the same unique key already exists.
+	ErrConflict = errors.New("conflict")
+	// ErrInvalid means the database rejected a value, e.g. one too long for
+	// its column or referencing a missing row.
+	ErrInvalid = errors.New("invalid value")
+	// ErrUnavailable means the database could not be reached or was too busy
+	// to answer; retrying later may succeed.
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// Error is a failed store operation.
+type Error struct {
+	// Op names the operation, e.g. "insert command".
+	Op string
+	// Kind is one of ErrNotFound, ErrConflict, ErrInvalid or ErrUnavailable,
+	// or nil when the failure could not be classified.
+	Kind error
+	// Err is the underlying driver error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// notFound is the error returned when op finds nothing. It also matches
+// sql.ErrNoRows, as the SQL backend's does.
+func notFound(op string) error {
+	return &Error{Op: op, Kind: ErrNotFound, Err: sql.ErrNoRows}
+}
+
+// conflict is the error returned when op would duplicate a unique key.
+func conflict(op string) error {
+	return &Error{Op: op, Kind: ErrConflict}
+}
+
+// wrapError turns a driver error from op into an *Error of the matching
+// kind. nil and errors that are already an *Error are returned unchanged.
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Op: op, Kind: errorKind(err), Err: err}
+}
+
+func errorKind(err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, context.DeadlineExceeded):
+		return ErrUnavailable
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code == "23505": // unique_violation
+			return ErrConflict
+		case pqErr.Code.Class() == "22", pqErr.Code.Class() == "23":
+			// data exceptions and other integrity constraint violations
+			return ErrInvalid
+		case pqErr.Code.Class() == "08", pqErr.Code.Class() == "53",
+			pqErr.Code.Class() == "57", pqErr.Code.Class() == "40":
+			// connection exceptions, insufficient resources, operator
+			// intervention such as a shutdown, and serialization failures
+			return ErrUnavailable
+		}
+		return nil
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return ErrConflict
+		}
+		// Extended result codes keep the primary code in their low byte.
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CONSTRAINT, sqlite3.SQLITE_MISMATCH, sqlite3.SQLITE_TOOBIG, sqlite3.SQLITE_RANGE:
+			return ErrInvalid
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_IOERR,
+			sqlite3.SQLITE_CANTOPEN, sqlite3.SQLITE_FULL, sqlite3.SQLITE_READONLY:
+			return ErrUnavailable
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// affected returns the number of rows changed by a successful Exec.
+func affected(op string, res sql.Result) (int64, error) {
+	n, err := res.RowsAffected()
+	return n, wrapError(op, err)
+}
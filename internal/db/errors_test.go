@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{sql.ErrNoRows, ErrNotFound},
+		{fmt.Errorf("scan: %w", sql.ErrNoRows), ErrNotFound},
+		{context.DeadlineExceeded, ErrUnavailable},
+		{context.Canceled, nil},
+		{&pq.Error{Code: "23505"}, ErrConflict},
+		{&pq.Error{Code: "23503"}, ErrInvalid},
+		{&pq.Error{Code: "22001"}, ErrInvalid},
+		{&pq.Error{Code: "08006"}, ErrUnavailable},
+		{&pq.Error{Code: "57P01"}, ErrUnavailable},
+		{&pq.Error{Code: "42601"}, nil},
+		{errors.New("something else"), nil},
+	}
+	for _, tt := range tests {
+		if got := errorKind(tt.err); got != tt.want {
+			t.Errorf("errorKind(%v): expected %v, got %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestWrapError(t *testing.T) {
+	if wrapError("op", nil) != nil {
+		t.Errorf("expected nil to stay nil")
+	}
+	err := wrapError("get thing", sql.ErrNoRows)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected %v to match both ErrNotFound and sql.ErrNoRows", err)
+	}
+	if err.Error() != "get thing: not found: sql: no rows in result set" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if again := wrapError("outer", err); again != err {
+		t.Errorf("expected an *Error to be returned unchanged, got %v", again)
+	}
+}
+
+func TestSQLiteErrorKinds(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewSQLStore(filepath.Join(t.TempDir(), "errors.db"), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	user := createTestUser(t, store, "kinds")
+	session := Session{ID: "kinds", UserID: user.ID}
+	if err := store.SessionCreate(ctx, session); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.SessionCreate(ctx, session); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected %v for a duplicate session, got %v", ErrConflict, err)
+	}
+	if err := store.SessionCreate(ctx, Session{ID: "orphan", UserID: user.ID + 1000}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected %v for a missing user, got %v", ErrInvalid, err)
+	}
+	if _, err := store.CommandGet(ctx, Command{User: user, Query: "(", Limit: 1}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("expected %v for a bad pattern, got %v", ErrInvalid, err)
+	}
+	store.Close()
+	if _, err := store.UserGetByID(ctx, user.ID); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a closed database not to report not found, got %v", err)
+	}
+}
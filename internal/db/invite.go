@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+const inviteColumns = `"id", "hash", "created_by", "max_uses", "uses", "created", "expires", "revoked"`
+
+func scanInvite(row interface{ Scan(...interface{}) error }) (Invite, error) {
+	var invite Invite
+	// created_by is NULL once the administrator who minted it is deleted.
+	var createdBy sql.NullInt64
+	err := row.Scan(&invite.ID, &invite.Hash, &createdBy, &invite.MaxUses, &invite.Uses,
+		&invite.Created, &invite.Expires, &invite.Revoked)
+	if err != nil {
+		return Invite{}, err
+	}
+	invite.CreatedBy = uint(createdBy.Int64)
+	return invite, nil
+}
+
+func (s *SQLStore) InviteCreate(ctx context.Context, invite Invite) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO invites (`+inviteColumns+`)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		invite.ID, invite.Hash, invite.CreatedBy, invite.MaxUses, invite.Uses,
+		invite.Created, invite.Expires, invite.Revoked)
+	return wrapError("create invite", err)
+}
+
+// InviteList returns every invite, newest first.
+func (s *SQLStore) InviteList(ctx context.Context) ([]Invite, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT `+inviteColumns+` FROM invites ORDER BY "created" DESC`)
+	if err != nil {
+		return nil, wrapError("list invites", err)
+	}
+	defer rows.Close()
+	invites := []Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, wrapError("list invites", err)
+		}
+		invites = append(invites, invite)
+	}
+	return invites, wrapError("list invites", rows.Err())
+}
+
+func (s *SQLStore) InviteRevoke(ctx context.Context, id string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `UPDATE invites SET "revoked" = TRUE WHERE "id" = $1 AND "revoked" = FALSE`, id)
+	if err != nil {
+		return 0, wrapError("revoke invite", err)
+	}
+	return affected("revoke invite", res)
+}
+
+// InviteRedeem uses up one registration of the invite with the given hash and
+// returns it. It returns ErrNotFound if the invite is unknown, revoked,
+// expired or used up; the check and the increment are a single statement so
+// concurrent registrations cannot exceed MaxUses.
+func (s *SQLStore) InviteRedeem(ctx context.Context, hash string, now int64) (Invite, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	invite, err := scanInvite(s.db.QueryRowContext(ctx, `
+	UPDATE invites SET "uses" = "uses" + 1
+		WHERE "hash" = $1 AND "revoked" = FALSE
+		AND ("expires" = 0 OR "expires" > $2)
+		AND ("max_uses" = 0 OR "uses" < "max_uses")
+		RETURNING `+inviteColumns, hash, now))
+	return invite, wrapError("redeem invite", err)
+}
+
+// InviteRelease gives back a use of the invite redeemed by a registration
+// that then failed, so the code keeps working.
+func (s *SQLStore) InviteRelease(ctx context.Context, id string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `UPDATE invites SET "uses" = "uses" - 1 WHERE "id" = $1 AND "uses" > 0`, id)
+	return wrapError("release invite", err)
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestInvites(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		admin := createTestUser(t, store, "inviter")
+		invites := []Invite{
+			{ID: "single", Hash: "hash-single", CreatedBy: admin.ID, MaxUses: 1, Created: 100},
+			{ID: "multi", Hash: "hash-multi", CreatedBy: admin.ID, MaxUses: 2, Created: 200},
+			{ID: "unlimited", Hash: "hash-unlimited", CreatedBy: admin.ID, Created: 300},
+			{ID: "expiring", Hash: "hash-expiring", CreatedBy: admin.ID, MaxUses: 1, Created: 400, Expires: 1000},
+		}
+		for _, invite := range invites {
+			if err := store.InviteCreate(ctx, invite); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if err := store.InviteCreate(ctx, invites[0]); err == nil {
+			t.Errorf("expected error for duplicate invite, got nil")
+		}
+
+		redeem := func(hash string, now int64, want error) {
+			t.Helper()
+			if _, err := store.InviteRedeem(ctx, hash, now); !errors.Is(err, want) {
+				t.Errorf("redeeming %v: expected %v, got %v", hash, want, err)
+			}
+		}
+		redeem("hash-single", 500, nil)
+		redeem("hash-single", 500, ErrNotFound)
+		redeem("hash-multi", 500, nil)
+		redeem("hash-multi", 500, nil)
+		redeem("hash-multi", 500, ErrNotFound)
+		for i := 0; i < 3; i++ {
+			redeem("hash-unlimited", 500, nil)
+		}
+		redeem("hash-expiring", 1000, ErrNotFound)
+		redeem("hash-missing", 500, ErrNotFound)
+		if err := store.InviteRelease(ctx, "single"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		redeem("hash-single", 500, nil)
+		redeem("hash-single", 500, ErrNotFound)
+
+		if revoked, _ := store.InviteRevoke(ctx, "expiring"); revoked != 1 {
+			t.Errorf("expected 1 revoked invite, got %d", revoked)
+		}
+		if revoked, _ := store.InviteRevoke(ctx, "expiring"); revoked != 0 {
+			t.Errorf("expected revoked invite not to be revoked again, got %d", revoked)
+		}
+		redeem("hash-expiring", 500, ErrNotFound)
+
+		list, err := store.InviteList(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []Invite{invites[3], invites[2], invites[1], invites[0]}
+		want[0].Revoked = true
+		want[1].Uses, want[2].Uses, want[3].Uses = 3, 2, 1
+		if len(list) != len(want) {
+			t.Fatalf("expected %d invites, got %+v", len(want), list)
+		}
+		for i := range want {
+			if list[i] != want[i] {
+				t.Errorf("expected %+v, got %+v", want[i], list[i])
+			}
+		}
+
+		// Invites outlive the administrator who minted them.
+		if deleted, err := store.UserDelete(ctx, admin.ID); err != nil || deleted != 1 {
+			t.Fatalf("expected 1 deleted user, got %d (%v)", deleted, err)
+		}
+		list, err = store.InviteList(ctx)
+		if err != nil || len(list) != len(want) {
+			t.Fatalf("expected %d invites, got %+v (%v)", len(want), list, err)
+		}
+		for _, invite := range list {
+			if invite.CreatedBy != 0 {
+				t.Errorf("expected invite without creator, got %+v", invite)
+			}
+		}
+		redeem("hash-unlimited", 500, nil)
+	})
+}
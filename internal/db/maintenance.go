@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Stats summarises the contents of a store for operators.
+type Stats struct {
+	Users    int64 `json:"users"`
+	Systems  int64 `json:"systems"`
+	Commands int64 `json:"commands"`
+	// Sessions counts the sessions that are neither revoked nor expired.
+	Sessions int64 `json:"sessions"`
+	APIKeys  int64 `json:"apiKeys"`
+	Invites  int64 `json:"invites"`
+}
+
+func (s *SQLStore) Stats(ctx context.Context) (Stats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var stats Stats
+	err := s.db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM systems),
+		(SELECT COUNT(*) FROM commands),
+		(SELECT COUNT(*) FROM sessions WHERE "revoked" = FALSE AND "expires" > $1),
+		(SELECT COUNT(*) FROM api_keys),
+		(SELECT COUNT(*) FROM invites)`, time.Now().Unix()).Scan(
+		&stats.Users, &stats.Systems, &stats.Commands, &stats.Sessions, &stats.APIKeys, &stats.Invites)
+	return stats, wrapError("stats", err)
+}
+
+// Vacuum reclaims the space left by deleted rows and refreshes the planner
+// statistics.
+func (s *SQLStore) Vacuum(ctx context.Context) error {
+	query := `VACUUM ANALYZE`
+	if s.dialect == dialectSQLite {
+		query = `VACUUM`
+	}
+	_, err := s.db.ExecContext(ctx, query)
+	return wrapError("vacuum", err)
+}
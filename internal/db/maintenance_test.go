@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		before, err := store.Stats(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		user := createTestUser(t, store, "statistician")
+		now := time.Now().Unix()
+		store.SessionCreate(ctx, Session{ID: "stats-live", UserID: user.ID, Created: now, Expires: now + 60})
+		store.SessionCreate(ctx, Session{ID: "stats-expired", UserID: user.ID, Created: now - 120, Expires: now - 60})
+		store.CommandInsert(ctx, Command{Uuid: "stats-command", Command: "ls", Path: "/", Created: 1, User: user})
+
+		after, err := store.Stats(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := before
+		want.Users++
+		want.Sessions++
+		want.Commands++
+		if after != want {
+			t.Errorf("expected %+v, got %+v", want, after)
+		}
+	})
+}
+
+func TestVacuum(t *testing.T) {
+	ctx := context.Background()
+	if err := testStore.Vacuum(ctx); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
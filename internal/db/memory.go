@@ -0,0 +1,838 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps everything in process memory. It mirrors
+// the semantics of SQLStore and backs the memory:// mode and handler tests;
+// all data is lost when the process exits.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	users    []User
+	systems  []System
+	commands []memoryCommand
+	sessions []Session
+	refresh  []RefreshToken
+	apiKeys  []APIKey
+	resets   []PasswordReset
+	invites  []Invite
+	secrets  []Secret
+	nextID   uint
+	// passwords is set by Open to the configured work factor.
+	passwords passwordHasher
+}
+
+// memoryCommand is a row of the commands table. processID is a pointer because
+// imported commands have no process, which SQL stores as NULL.
+type memoryCommand struct {
+	Command
+	userID    uint
+	processID *int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func (m *MemoryStore) newID() uint {
+	m.nextID++
+	return m.nextID
+}
+
+func (m *MemoryStore) findUser(username string) (User, bool) {
+	for _, u := range m.users {
+		if u.Username == username {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (m *MemoryStore) GetSecret(ctx context.Context) (string, error) {
+	secrets, err := m.GetSecrets(ctx)
+	if err != nil {
+		return "", err
+	}
+	return secrets[0].Secret, nil
+}
+
+func (m *MemoryStore) GetSecrets(ctx context.Context) ([]Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.secrets) == 0 {
+		secret, err := newSecret()
+		if err != nil {
+			return nil, err
+		}
+		m.secrets = append(m.secrets, secret)
+	}
+	var secrets []Secret
+	for i := len(m.secrets) - 1; i >= 0 && len(secrets) < 2; i-- {
+		secrets = append(secrets, m.secrets[i])
+	}
+	return secrets, nil
+}
+
+func (m *MemoryStore) RotateSecret(ctx context.Context) (Secret, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	secret, err := newSecret()
+	if err != nil {
+		return Secret{}, err
+	}
+	m.secrets = append(m.secrets, secret)
+	return secret, nil
+}
+
+// UserExists checks the user's password. Hashing is slow, so it runs without
+// holding the store lock.
+func (m *MemoryStore) UserExists(ctx context.Context, user User) error {
+	m.mu.RLock()
+	u, ok := m.findUser(user.Username)
+	m.mu.RUnlock()
+	if !ok {
+		return notFound("check credentials")
+	}
+	if err := ComparePasswords(u.Password, user.Password); err != nil {
+		return notFound("check credentials")
+	}
+	if !m.passwords.needsRehash(u.Password) {
+		return nil
+	}
+	rehashed := m.passwords.hash(user.Password)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		// Leave a password changed while hashing alone.
+		if m.users[i].ID == u.ID && m.users[i].Password == u.Password {
+			m.users[i].Password = rehashed
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UserGetID(ctx context.Context, user User) (uint, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.findUser(user.Username)
+	if !ok {
+		return 0, notFound("get user id")
+	}
+	return u.ID, nil
+}
+
+func (m *MemoryStore) UserGetByID(ctx context.Context, id uint) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if u.ID == id {
+			return User{ID: u.ID, Username: u.Username, Email: u.Email, IsAdmin: u.IsAdmin, Disabled: u.Disabled}, nil
+		}
+	}
+	return User{}, notFound("get user")
+}
+
+func (m *MemoryStore) UserGetSystemName(ctx context.Context, user User) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.findUser(user.Username)
+	if !ok || user.Mac == nil {
+		return "", nil
+	}
+	for _, sys := range m.systems {
+		if sys.UserId == u.ID && sys.Mac == *user.Mac {
+			if sys.Name == nil {
+				return "", nil
+			}
+			return *sys.Name, nil
+		}
+	}
+	return "", nil
+}
+
+func (m *MemoryStore) UsernameExists(ctx context.Context, user User) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.findUser(user.Username)
+	return ok, nil
+}
+
+func (m *MemoryStore) EmailExists(ctx context.Context, user User) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, u := range m.users {
+		if u.Email == user.Email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) UserCreate(ctx context.Context, user User) (int64, error) {
+	m.mu.RLock()
+	_, exists := m.findUser(user.Username)
+	m.mu.RUnlock()
+	if exists {
+		return 0, nil
+	}
+	password := m.passwords.hash(user.Password)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// The username may have been taken while the password was hashed.
+	if _, ok := m.findUser(user.Username); ok {
+		return 0, nil
+	}
+	m.users = append(m.users, User{
+		ID:               m.newID(),
+		Username:         user.Username,
+		Email:            user.Email,
+		Password:         password,
+		RegistrationCode: user.RegistrationCode,
+	})
+	return 1, nil
+}
+
+func (m *MemoryStore) UserSetPassword(ctx context.Context, id uint, password string) (int64, error) {
+	hashed := m.passwords.hash(password)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Password = hashed
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) UserList(ctx context.Context) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := []User{}
+	for _, u := range m.users {
+		users = append(users, User{ID: u.ID, Username: u.Username, Email: u.Email, IsAdmin: u.IsAdmin, Disabled: u.Disabled})
+	}
+	return users, nil
+}
+
+func (m *MemoryStore) UserCount(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.users), nil
+}
+
+func (m *MemoryStore) UserSetAdmin(ctx context.Context, id uint, admin bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].IsAdmin = admin
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) UserSetDisabled(ctx context.Context, id uint, disabled bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Disabled = disabled
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) UserDelete(ctx context.Context, id uint) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	users := m.users[:0]
+	for _, u := range m.users {
+		if u.ID == id {
+			deleted++
+			continue
+		}
+		users = append(users, u)
+	}
+	m.users = users
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	sessions := m.sessions[:0]
+	removed := make(map[string]bool)
+	for _, session := range m.sessions {
+		if session.UserID == id {
+			removed[session.ID] = true
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	m.sessions = sessions
+	refresh := m.refresh[:0]
+	for _, token := range m.refresh {
+		if !removed[token.SessionID] {
+			refresh = append(refresh, token)
+		}
+	}
+	m.refresh = refresh
+	apiKeys := m.apiKeys[:0]
+	for _, key := range m.apiKeys {
+		if key.UserID != id {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	m.apiKeys = apiKeys
+	resets := m.resets[:0]
+	for _, reset := range m.resets {
+		if reset.UserID != id {
+			resets = append(resets, reset)
+		}
+	}
+	m.resets = resets
+	for i := range m.invites {
+		if m.invites[i].CreatedBy == id {
+			m.invites[i].CreatedBy = 0
+		}
+	}
+	commands := m.commands[:0]
+	for _, c := range m.commands {
+		if c.userID != id {
+			commands = append(commands, c)
+		}
+	}
+	m.commands = commands
+	systems := m.systems[:0]
+	for _, sys := range m.systems {
+		if sys.UserId != id {
+			systems = append(systems, sys)
+		}
+	}
+	m.systems = systems
+	return deleted, nil
+}
+
+func (m *MemoryStore) Stats(ctx context.Context) (Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stats := Stats{
+		Users:    int64(len(m.users)),
+		Systems:  int64(len(m.systems)),
+		Commands: int64(len(m.commands)),
+		APIKeys:  int64(len(m.apiKeys)),
+		Invites:  int64(len(m.invites)),
+	}
+	now := time.Now().Unix()
+	for _, session := range m.sessions {
+		if !session.Revoked && session.Expires > now {
+			stats.Sessions++
+		}
+	}
+	return stats, nil
+}
+
+func (m *MemoryStore) SystemInsert(ctx context.Context, sys System) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := time.Now().Unix()
+	m.systems = append(m.systems, System{
+		ID:            m.newID(),
+		Created:       t,
+		Updated:       t,
+		Mac:           sys.Mac,
+		Hostname:      sys.Hostname,
+		Name:          sys.Name,
+		ClientVersion: sys.ClientVersion,
+		UserId:        sys.User.ID,
+	})
+	return 1, nil
+}
+
+func (m *MemoryStore) SystemUpdate(ctx context.Context, sys System) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var updated int64
+	t := time.Now().Unix()
+	for i := range m.systems {
+		if m.systems[i].UserId == sys.User.ID && m.systems[i].Mac == sys.Mac {
+			m.systems[i].Hostname = sys.Hostname
+			m.systems[i].Updated = t
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+func (m *MemoryStore) SystemGet(ctx context.Context, sys System) (System, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, row := range m.systems {
+		if row.UserId == sys.User.ID && row.Mac == sys.Mac {
+			return row, nil
+		}
+	}
+	return System{}, notFound("get system")
+}
+
+func (m *MemoryStore) commandExists(uuid string) bool {
+	for _, c := range m.commands {
+		if c.Uuid == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) CommandInsert(ctx context.Context, cmd Command) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.commandExists(cmd.Uuid) {
+		return 0, nil
+	}
+	pid := cmd.ProcessId
+	m.commands = append(m.commands, memoryCommand{Command: cmd, userID: cmd.User.ID, processID: &pid})
+	return 1, nil
+}
+
+func (m *MemoryStore) CommandGet(ctx context.Context, cmd Command) ([]Query, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matches := func(c memoryCommand) bool { return true }
+	if cmd.Query != "" {
+		re, err := compileRegexp(cmd.Query)
+		if err != nil {
+			return []Query{}, &Error{Op: "search commands", Kind: ErrInvalid, Err: err}
+		}
+		matches = func(c memoryCommand) bool { return re.MatchString(c.Command.Command) }
+	}
+
+	var rows []memoryCommand
+	for _, c := range m.commands {
+		if c.userID != cmd.User.ID ||
+			(cmd.Path != "" && c.Path != cmd.Path) ||
+			(cmd.SystemName != "" && c.SystemName != cmd.SystemName) ||
+			!matches(c) {
+			continue
+		}
+		rows = append(rows, c)
+	}
+
+	if cmd.Unique {
+		latest := make(map[string]memoryCommand)
+		for _, c := range rows {
+			if prev, ok := latest[c.Command.Command]; !ok || c.Created > prev.Created {
+				latest[c.Command.Command] = c
+			}
+		}
+		rows = rows[:0]
+		for _, c := range latest {
+			rows = append(rows, c)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Command.Command < rows[j].Command.Command })
+	} else {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].Created > rows[j].Created })
+	}
+	if cmd.Limit >= 0 && len(rows) > cmd.Limit {
+		rows = rows[:cmd.Limit]
+	}
+
+	var results []Query
+	for _, c := range rows {
+		results = append(results, Query{Command: c.Command.Command, Uuid: c.Uuid, Created: c.Created})
+	}
+	return results, nil
+}
+
+func (m *MemoryStore) CommandGetUUID(ctx context.Context, cmd Command) (Query, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, c := range m.commands {
+		if c.Uuid == cmd.Uuid && c.userID == cmd.User.ID {
+			// Like SQLStore, the process id is reported in the sessionId field.
+			var session *string
+			if c.processID != nil {
+				pid := strconv.Itoa(*c.processID)
+				session = &pid
+			}
+			return Query{
+				Command:    c.Command.Command,
+				Path:       c.Path,
+				Created:    c.Created,
+				Uuid:       c.Uuid,
+				ExitStatus: c.ExitStatus,
+				SystemName: c.SystemName,
+				SessionID:  session,
+			}, nil
+		}
+	}
+	return Query{}, notFound("get command")
+}
+
+func (m *MemoryStore) CommandDelete(ctx context.Context, cmd Command) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	kept := m.commands[:0]
+	for _, c := range m.commands {
+		if c.userID == cmd.User.ID && c.Uuid == cmd.Uuid {
+			deleted++
+			continue
+		}
+		kept = append(kept, c)
+	}
+	m.commands = kept
+	return deleted, nil
+}
+
+func (m *MemoryStore) StatusGet(ctx context.Context, status Status) (Status, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	today := time.Now().UTC().Format("2006-01-02")
+	sessions := make(map[int]bool)
+	status.TotalCommands, status.TotalCommandsToday, status.SessionTotalCommands = 0, 0, 0
+	for _, c := range m.commands {
+		if c.processID != nil && *c.processID == status.ProcessID {
+			status.SessionTotalCommands++
+		}
+		if c.userID != status.User.ID {
+			continue
+		}
+		status.TotalCommands++
+		if c.processID != nil {
+			sessions[*c.processID] = true
+		}
+		if time.Unix(c.Created/1000, 0).UTC().Format("2006-01-02") == today {
+			status.TotalCommandsToday++
+		}
+	}
+	status.TotalSessions = len(sessions)
+	status.TotalSystems = 0
+	for _, sys := range m.systems {
+		if sys.UserId == status.User.ID {
+			status.TotalSystems++
+		}
+	}
+	return status, nil
+}
+
+func (m *MemoryStore) ImportCommands(ctx context.Context, imp Import) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.commandExists(imp.Uuid) {
+		return nil
+	}
+	u, _ := m.findUser(imp.Username)
+	m.commands = append(m.commands, memoryCommand{
+		Command: Command{
+			Command:    imp.Command,
+			Path:       imp.Path,
+			Created:    imp.Created,
+			Uuid:       imp.Uuid,
+			ExitStatus: imp.ExitStatus,
+			SystemName: imp.SystemName,
+		},
+		userID: u.ID,
+	})
+	return nil
+}
+
+func (m *MemoryStore) SessionCreate(ctx context.Context, session Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.sessions {
+		if existing.ID == session.ID {
+			return conflict("create session")
+		}
+	}
+	session.Revoked = false
+	session.Current = false
+	m.sessions = append(m.sessions, session)
+	return nil
+}
+
+func (m *MemoryStore) SessionGet(ctx context.Context, id string) (Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, session := range m.sessions {
+		if session.ID == id {
+			return session, nil
+		}
+	}
+	return Session{}, notFound("get session")
+}
+
+func (m *MemoryStore) SessionList(ctx context.Context, userID uint) ([]Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now().Unix()
+	sessions := []Session{}
+	for _, session := range m.sessions {
+		if session.UserID == userID && !session.Revoked && session.Expires > now {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Created > sessions[j].Created })
+	return sessions, nil
+}
+
+func (m *MemoryStore) SessionRevoke(ctx context.Context, userID uint, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked int64
+	for i := range m.sessions {
+		if m.sessions[i].UserID == userID && m.sessions[i].ID == id && !m.sessions[i].Revoked {
+			m.sessions[i].Revoked = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MemoryStore) SessionRevokeAll(ctx context.Context, userID uint) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked int64
+	for i := range m.sessions {
+		if m.sessions[i].UserID == userID && !m.sessions[i].Revoked {
+			m.sessions[i].Revoked = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MemoryStore) SessionRevokeOthers(ctx context.Context, userID uint, keep string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var revoked int64
+	for i := range m.sessions {
+		if m.sessions[i].UserID == userID && m.sessions[i].ID != keep && !m.sessions[i].Revoked {
+			m.sessions[i].Revoked = true
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MemoryStore) SessionExtend(ctx context.Context, id string, expires int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.sessions {
+		if m.sessions[i].ID == id && m.sessions[i].Expires < expires {
+			m.sessions[i].Expires = expires
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) RefreshTokenCreate(ctx context.Context, token RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.refresh {
+		if existing.Hash == token.Hash {
+			return conflict("create refresh token")
+		}
+	}
+	token.Used = false
+	m.refresh = append(m.refresh, token)
+	return nil
+}
+
+func (m *MemoryStore) RefreshTokenUse(ctx context.Context, hash string) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.refresh {
+		if m.refresh[i].Hash == hash {
+			token := m.refresh[i]
+			m.refresh[i].Used = true
+			return token, nil
+		}
+	}
+	return RefreshToken{}, notFound("use refresh token")
+}
+
+func (m *MemoryStore) APIKeyCreate(ctx context.Context, key APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.apiKeys {
+		if existing.ID == key.ID || existing.Hash == key.Hash {
+			return conflict("create api key")
+		}
+	}
+	m.apiKeys = append(m.apiKeys, key)
+	return nil
+}
+
+func (m *MemoryStore) APIKeyGet(ctx context.Context, userID uint, id string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.apiKeys {
+		if key.UserID == userID && key.ID == id {
+			return key, nil
+		}
+	}
+	return APIKey{}, notFound("get api key")
+}
+
+func (m *MemoryStore) APIKeyGetByHash(ctx context.Context, hash string) (APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.apiKeys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, notFound("get api key")
+}
+
+func (m *MemoryStore) APIKeyList(ctx context.Context, userID uint) ([]APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := []APIKey{}
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].Created > keys[j].Created })
+	return keys, nil
+}
+
+func (m *MemoryStore) APIKeyUpdate(ctx context.Context, key APIKey) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.apiKeys {
+		if m.apiKeys[i].UserID == key.UserID && m.apiKeys[i].ID == key.ID {
+			m.apiKeys[i].Name = key.Name
+			m.apiKeys[i].Scope = key.Scope
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) APIKeyTouch(ctx context.Context, id string, lastUsed int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.apiKeys {
+		if m.apiKeys[i].ID == id {
+			m.apiKeys[i].LastUsed = lastUsed
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) APIKeyDelete(ctx context.Context, userID uint, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	kept := m.apiKeys[:0]
+	for _, key := range m.apiKeys {
+		if key.UserID == userID && key.ID == id {
+			deleted++
+			continue
+		}
+		kept = append(kept, key)
+	}
+	m.apiKeys = kept
+	return deleted, nil
+}
+
+func (m *MemoryStore) PasswordResetCreate(ctx context.Context, reset PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.resets {
+		if existing.Hash == reset.Hash {
+			return conflict("create password reset")
+		}
+	}
+	reset.Used = false
+	m.resets = append(m.resets, reset)
+	return nil
+}
+
+func (m *MemoryStore) PasswordResetUse(ctx context.Context, hash string) (PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.resets {
+		if m.resets[i].Hash == hash {
+			reset := m.resets[i]
+			m.resets[i].Used = true
+			return reset, nil
+		}
+	}
+	return PasswordReset{}, notFound("use password reset")
+}
+
+func (m *MemoryStore) InviteCreate(ctx context.Context, invite Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.invites {
+		if existing.ID == invite.ID || existing.Hash == invite.Hash {
+			return conflict("create invite")
+		}
+	}
+	m.invites = append(m.invites, invite)
+	return nil
+}
+
+func (m *MemoryStore) InviteList(ctx context.Context) ([]Invite, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	invites := append([]Invite{}, m.invites...)
+	sort.SliceStable(invites, func(i, j int) bool { return invites[i].Created > invites[j].Created })
+	return invites, nil
+}
+
+func (m *MemoryStore) InviteRevoke(ctx context.Context, id string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.invites {
+		if m.invites[i].ID == id && !m.invites[i].Revoked {
+			m.invites[i].Revoked = true
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) InviteRedeem(ctx context.Context, hash string, now int64) (Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.invites {
+		invite := &m.invites[i]
+		if invite.Hash != hash || invite.Revoked ||
+			(invite.Expires != 0 && invite.Expires <= now) ||
+			(invite.MaxUses != 0 && invite.Uses >= invite.MaxUses) {
+			continue
+		}
+		invite.Uses++
+		return *invite, nil
+	}
+	return Invite{}, notFound("redeem invite")
+}
+
+func (m *MemoryStore) InviteRelease(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.invites {
+		if m.invites[i].ID == id && m.invites[i].Uses > 0 {
+			m.invites[i].Uses--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations of each dialect, named
+// NNNN_description.sql and applied in version order.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary does not know, i.e. it was migrated by a newer release.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// ErrSchemaOutdated is returned when migrations are pending but the store was
+// opened without applying them.
+var ErrSchemaOutdated = errors.New("database schema is out of date, run bashhub-server db migrate")
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadMigrations returns the migrations of dialect in version order. Versions
+// must start at 1 and have no gaps.
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		number, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name %s", name)
+		}
+		body, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: strings.TrimSuffix(name, ".sql"), sql: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %s is out of sequence, expected version %d", m.name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the
+// database, 0 for an empty database.
+func (s *SQLStore) SchemaVersion(ctx context.Context) (int, error) {
+	if err := s.createMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+	var version int
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX("version"), 0) FROM schema_migrations`).Scan(&version)
+	return version, wrapError("get schema version", err)
+}
+
+// PendingMigrations returns how many known migrations have not been applied.
+// It fails with ErrSchemaTooNew if the database is ahead of this binary.
+func (s *SQLStore) PendingMigrations(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return 0, err
+	}
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return 0, fmt.Errorf("%w: database is at version %d, this binary supports up to %d",
+			ErrSchemaTooNew, version, len(migrations))
+	}
+	return len(migrations) - version, nil
+}
+
+// migrationLockID is the Postgres advisory lock held while migrating.
+const migrationLockID = 0x62617368 // "bash"
+
+// Migrate applies the pending migrations in order, each in its own
+// transaction together with its schema_migrations row, and returns how many
+// were applied. Processes migrating the same database at once take turns:
+// on Postgres behind an advisory lock, on SQLite by beginning each
+// migration with BEGIN IMMEDIATE. A migration another process applied in
+// the meantime is skipped.
+func (s *SQLStore) Migrate(ctx context.Context) (int, error) {
+	pending, err := s.PendingMigrations(ctx)
+	if err != nil || pending == 0 {
+		return 0, err
+	}
+	migrations, err := loadMigrations(s.dialect)
+	if err != nil {
+		return 0, err
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, wrapError("migrate", err)
+	}
+	defer conn.Close()
+	if s.dialect == dialectPostgres {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+			return 0, wrapError("lock migrations", err)
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}
+	applied := 0
+	for _, m := range migrations[len(migrations)-pending:] {
+		ok, err := s.applyMigration(ctx, conn, m)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// migrationTx is the part of *sql.Tx and *sql.Conn a migration runs on.
+type migrationTx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// applyMigration applies m on conn unless it is already recorded, and
+// reports whether it did.
+func (s *SQLStore) applyMigration(ctx context.Context, conn *sql.Conn, m migration) (bool, error) {
+	op := "apply migration " + m.name
+	var tx migrationTx
+	commit := func() error { _, err := conn.ExecContext(ctx, `COMMIT`); return err }
+	if s.dialect == dialectSQLite {
+		// database/sql has no way to ask for an immediate transaction, which
+		// takes the write lock before schema_migrations is read.
+		if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+			return false, wrapError(op, err)
+		}
+		defer conn.ExecContext(context.Background(), `ROLLBACK`)
+		tx = conn
+	} else {
+		sqlTx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return false, wrapError(op, err)
+		}
+		defer sqlTx.Rollback()
+		tx, commit = sqlTx, sqlTx.Commit
+	}
+
+	var done bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE "version" = $1)`, m.version).Scan(&done); err != nil {
+		return false, wrapError(op, err)
+	}
+	if done {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return false, wrapError(op, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations ("version", "name", "applied") VALUES ($1, $2, $3)`,
+		m.version, m.name, time.Now().Unix()); err != nil {
+		return false, wrapError(op, err)
+	}
+	return true, wrapError(op, commit())
+}
+
+func (s *SQLStore) createMigrationsTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied BIGINT NOT NULL
+	)`)
+	return wrapError("create schema_migrations", err)
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(postgres) == 0 || len(postgres) != len(sqlite) {
+		t.Fatalf("expected the same migrations for both dialects, got %d and %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].name != sqlite[i].name || postgres[i].version != i+1 {
+			t.Errorf("migration %d differs: %v and %v", i+1, postgres[i].name, sqlite[i].name)
+		}
+	}
+}
+
+// openRaw opens a SQLite file without touching its schema.
+func openRaw(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	raw, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	return raw
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	raw := openRaw(t, path)
+	// The schema as created before versioned migrations, with a user in it.
+	for _, query := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, username VARCHAR(200) UNIQUE NOT NULL,
+			email VARCHAR(255), password VARCHAR(255), registration_code VARCHAR(255))`,
+		`CREATE TABLE systems (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(255), mac VARCHAR(255),
+			user_id INTEGER REFERENCES users(id), hostname VARCHAR(255), client_version VARCHAR(255),
+			created BIGINT, updated BIGINT)`,
+		`CREATE TABLE commands (id INTEGER PRIMARY KEY AUTOINCREMENT, command TEXT, path VARCHAR(255),
+			created BIGINT, uuid VARCHAR(255) UNIQUE NOT NULL, exit_status INTEGER, system_name VARCHAR(255),
+			process_id INTEGER, process_start_time BIGINT, user_id INTEGER REFERENCES users(id),
+			session_id VARCHAR(255))`,
+		`CREATE TABLE configs (id INTEGER PRIMARY KEY AUTOINCREMENT, created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			secret VARCHAR(255))`,
+		`INSERT INTO users (username, email, password) VALUES ('veteran', 'veteran@example.com', 'x')`,
+	} {
+		if _, err := raw.Exec(query); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	store, err := NewSQLStore(path, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer store.Close()
+	migrations, _ := loadMigrations(dialectSQLite)
+	if version, err := store.SchemaVersion(ctx); err != nil || version != len(migrations) {
+		t.Errorf("expected version %d, got %d (%v)", len(migrations), version, err)
+	}
+	user, err := store.UserGetByID(ctx, 1)
+	if err != nil || user.Username != "veteran" || user.IsAdmin {
+		t.Errorf("expected legacy user to survive, got %+v (%v)", user, err)
+	}
+	if applied, err := store.Migrate(ctx); err != nil || applied != 0 {
+		t.Errorf("expected nothing left to migrate, got %d (%v)", applied, err)
+	}
+}
+
+func TestSchemaVersionChecks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "versions.db")
+	if _, err := NewSQLStore(path, Options{SkipMigrations: true}); !errors.Is(err, ErrSchemaOutdated) {
+		t.Errorf("expected %v, got %v", ErrSchemaOutdated, err)
+	}
+	store, err := NewSQLStore(path, Options{SkipMigrations: true, AllowOutdated: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	migrations, _ := loadMigrations(dialectSQLite)
+	if pending, err := store.PendingMigrations(ctx); err != nil || pending != len(migrations) {
+		t.Errorf("expected %d pending, got %d (%v)", len(migrations), pending, err)
+	}
+	if applied, err := store.Migrate(ctx); err != nil || applied != len(migrations) {
+		t.Errorf("expected %d applied, got %d (%v)", len(migrations), applied, err)
+	}
+	store.Close()
+
+	// A release with more migrations has run against the database.
+	raw := openRaw(t, path)
+	if _, err := raw.Exec(`INSERT INTO schema_migrations (version, name, applied) VALUES (999, '0999_future', 0)`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	raw.Close()
+	if _, err := NewSQLStore(path, Options{}); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected %v, got %v", ErrSchemaTooNew, err)
+	}
+}
+
+func TestConcurrentMigrate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "concurrent.db")
+	var stores []*SQLStore
+	for i := 0; i < 4; i++ {
+		store, err := NewSQLStore(path, Options{SkipMigrations: true, AllowOutdated: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer store.Close()
+		stores = append(stores, store)
+	}
+	applied := make(chan int, len(stores))
+	for _, store := range stores {
+		go func() {
+			n, err := store.Migrate(ctx)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			applied <- n
+		}()
+	}
+	total := 0
+	for range stores {
+		total += <-applied
+	}
+	migrations, _ := loadMigrations(dialectSQLite)
+	if total != len(migrations) {
+		t.Errorf("expected %d migrations applied once, got %d", len(migrations), total)
+	}
+}
@@ -0,0 +1,46 @@
+-- The schema as first released. Databases created before versioned
+-- migrations already have it, hence IF NOT EXISTS.
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(200) UNIQUE NOT NULL,
+	email VARCHAR(255),
+	password VARCHAR(255),
+	registration_code VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS systems (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(255),
+	mac VARCHAR(255),
+	user_id INTEGER REFERENCES users(id),
+	hostname VARCHAR(255),
+	client_version VARCHAR(255),
+	created BIGINT,
+	updated BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS commands (
+	id SERIAL PRIMARY KEY,
+	command TEXT,
+	path VARCHAR(255),
+	created BIGINT,
+	uuid VARCHAR(255) UNIQUE NOT NULL,
+	exit_status INTEGER,
+	system_name VARCHAR(255),
+	process_id INTEGER,
+	process_start_time BIGINT,
+	user_id INTEGER REFERENCES users(id),
+	session_id VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS configs (
+	id SERIAL PRIMARY KEY,
+	created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	secret VARCHAR(255)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user ON users(username);
+CREATE INDEX IF NOT EXISTS idx_mac ON systems(mac);
+CREATE INDEX IF NOT EXISTS idx_user_command_created ON commands(user_id, created, command);
+CREATE INDEX IF NOT EXISTS idx_user_uuid ON commands(user_id, uuid);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_uuid ON commands(uuid);
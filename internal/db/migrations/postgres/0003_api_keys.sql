@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id VARCHAR(255) PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id),
+	name VARCHAR(255),
+	hash VARCHAR(64) UNIQUE NOT NULL,
+	scope VARCHAR(32) NOT NULL,
+	created BIGINT,
+	expires BIGINT,
+	last_used BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_user ON api_keys(user_id);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+	hash VARCHAR(64) PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id),
+	created BIGINT,
+	expires BIGINT,
+	used BOOLEAN NOT NULL DEFAULT FALSE
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS invites (
+	id VARCHAR(255) PRIMARY KEY,
+	hash VARCHAR(64) UNIQUE NOT NULL,
+	created_by INTEGER REFERENCES users(id),
+	max_uses INTEGER NOT NULL DEFAULT 1,
+	uses INTEGER NOT NULL DEFAULT 0,
+	created BIGINT,
+	expires BIGINT,
+	revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
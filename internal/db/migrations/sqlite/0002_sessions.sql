@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id VARCHAR(255) PRIMARY KEY,
+	user_id INTEGER REFERENCES users(id),
+	system_name VARCHAR(255),
+	created BIGINT,
+	expires BIGINT,
+	revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	hash VARCHAR(64) PRIMARY KEY,
+	session_id VARCHAR(255) REFERENCES sessions(id),
+	created BIGINT,
+	expires BIGINT,
+	used BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_session ON refresh_tokens(session_id);
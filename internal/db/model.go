@@ -1,4 +1,7 @@
 package db
+
+import "time"
+
 type User struct {
 	ID               uint    `json:"id" gorm:"primary_key"`
 	Username         string  `json:"Username" gorm:"type:varchar(200);unique_index"`
@@ -7,6 +10,8 @@ type User struct {
 	Mac              *string `json:"mac" gorm:"-"`
 	RegistrationCode *string `json:"registrationCode"`
 	SystemName       string  `json:"systemName" gorm:"-"`
+	IsAdmin          bool    `json:"isAdmin"`
+	Disabled         bool    `json:"disabled"`
 }
 type Query struct {
 	Command    string  `json:"command"`
@@ -58,3 +63,74 @@ type Status struct {
 	SessionTotalCommands int    `json:"sessionTotalCommands"`
 }
 type Import Query
+
+// Secret is a JWT signing key kept in the configs table.
+type Secret struct {
+	Secret  string
+	Created time.Time
+}
+
+// Session is a device login, identified by the sid claim of its tokens. It
+// stays valid while its access or refresh tokens do, unless revoked.
+type Session struct {
+	ID         string `json:"id"`
+	UserID     uint   `json:"-"`
+	SystemName string `json:"systemName"`
+	Created    int64  `json:"created"`
+	Expires    int64  `json:"expires"`
+	Revoked    bool   `json:"-"`
+	Current    bool   `json:"current"`
+}
+
+// RefreshToken is a single-use token exchanged for a new access token. Only a
+// hash of the token is stored.
+type RefreshToken struct {
+	Hash      string
+	SessionID string
+	Created   int64
+	Expires   int64
+	Used      bool
+}
+
+// API key scopes: read-only keys may only make GET requests.
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "read-write"
+)
+
+// APIKey is a long-lived credential a user creates for scripts. Only a hash
+// of the key is stored; Expires and LastUsed are zero when unset.
+type APIKey struct {
+	ID       string `json:"id"`
+	UserID   uint   `json:"-"`
+	Name     string `json:"name"`
+	Hash     string `json:"-"`
+	Scope    string `json:"scope"`
+	Created  int64  `json:"created"`
+	Expires  int64  `json:"expires"`
+	LastUsed int64  `json:"lastUsed"`
+}
+
+// PasswordReset is a one-time token that lets a user set a new password
+// without the old one. Only a hash of the token is stored.
+type PasswordReset struct {
+	Hash    string
+	UserID  uint
+	Created int64
+	Expires int64
+	Used    bool
+}
+
+// Invite is a registration code minted by an administrator. MaxUses of zero
+// allows unlimited registrations and Expires of zero never expires. Only a
+// hash of the code is stored.
+type Invite struct {
+	ID        string `json:"id"`
+	Hash      string `json:"-"`
+	CreatedBy uint   `json:"-"`
+	MaxUses   int    `json:"maxUses"`
+	Uses      int    `json:"uses"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires"`
+	Revoked   bool   `json:"revoked"`
+}
@@ -0,0 +1,148 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password hashes are stored in the PHC string format
+//
+//	$pbkdf2-sha256$i=<iterations>$<salt>$<hash>
+//
+// with unpadded base64 salt and hash, so the parameters can be raised later
+// without breaking existing hashes. Hashes written before this format were
+// hex(salt || sha256(password || salt)) and are still accepted; needsRehash
+// reports them so they are upgraded on the next successful login.
+const (
+	passwordScheme     = "pbkdf2-sha256"
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+	legacySaltLength   = 16
+)
+
+// defaultPasswordIterations is the PBKDF2 work factor for new hashes,
+// following the OWASP recommendation for PBKDF2-HMAC-SHA256. Hashes with
+// fewer iterations are upgraded on login.
+const defaultPasswordIterations = 600000
+
+var passwordEncoding = base64.RawStdEncoding
+
+// passwordHasher hashes new passwords. Stores take iterations from
+// Options.PasswordIterations; zero uses defaultPasswordIterations.
+type passwordHasher struct {
+	iterations int
+}
+
+func (h passwordHasher) work() int {
+	if h.iterations > 0 {
+		return h.iterations
+	}
+	return defaultPasswordIterations
+}
+
+func (h passwordHasher) hash(password string) string {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		log.Println("Failed to generate salt:", err)
+		return ""
+	}
+	return encodePasswordHash(h.work(), salt, pbkdf2SHA256([]byte(password), salt, h.work(), passwordKeyLength))
+}
+
+// needsRehash reports whether a stored hash uses the legacy format or weaker
+// parameters than h produces.
+func (h passwordHasher) needsRehash(hashedPwd string) bool {
+	iterations, _, key, err := parsePasswordHash(hashedPwd)
+	return err != nil || iterations < h.work() || len(key) < passwordKeyLength
+}
+
+// HashAndSalt hashes password with the default work factor.
+func HashAndSalt(password string) string {
+	return passwordHasher{}.hash(password)
+}
+
+func encodePasswordHash(iterations int, salt, key []byte) string {
+	return fmt.Sprintf("$%s$i=%d$%s$%s", passwordScheme, iterations,
+		passwordEncoding.EncodeToString(salt), passwordEncoding.EncodeToString(key))
+}
+
+// parsePasswordHash splits a PHC encoded hash into its parameters.
+func parsePasswordHash(hashedPwd string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hashedPwd, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != passwordScheme {
+		return 0, nil, nil, fmt.Errorf("invalid hash format")
+	}
+	if !strings.HasPrefix(parts[2], "i=") {
+		return 0, nil, nil, fmt.Errorf("invalid hash parameters")
+	}
+	iterations, err = strconv.Atoi(strings.TrimPrefix(parts[2], "i="))
+	if err != nil || iterations < 1 {
+		return 0, nil, nil, fmt.Errorf("invalid hash iterations")
+	}
+	if salt, err = passwordEncoding.DecodeString(parts[3]); err != nil {
+		return 0, nil, nil, fmt.Errorf("invalid hash salt")
+	}
+	if key, err = passwordEncoding.DecodeString(parts[4]); err != nil || len(key) == 0 {
+		return 0, nil, nil, fmt.Errorf("invalid hash")
+	}
+	return iterations, salt, key, nil
+}
+
+func ComparePasswords(hashedPwd string, plainPwd string) error {
+	if !strings.HasPrefix(hashedPwd, "$") {
+		return compareLegacyPassword(hashedPwd, plainPwd)
+	}
+	iterations, salt, key, err := parsePasswordHash(hashedPwd)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(pbkdf2SHA256([]byte(plainPwd), salt, iterations, len(key)), key) {
+		return fmt.Errorf("password mismatch")
+	}
+	return nil
+}
+
+// compareLegacyPassword checks a hash in the original single round SHA-256
+// format.
+func compareLegacyPassword(hashedPwd string, plainPwd string) error {
+	data, err := hex.DecodeString(hashedPwd)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 32 {
+		return fmt.Errorf("invalid hash format")
+	}
+
+	salt := data[:legacySaltLength]
+	storedHash := data[legacySaltLength:]
+
+	hash := sha256.Sum256(append([]byte(plainPwd), salt...))
+
+	if !hmac.Equal(hash[:], storedHash) {
+		return fmt.Errorf("password mismatch")
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether a stored hash uses the legacy format or weaker
+// parameters than HashAndSalt produces.
+func NeedsRehash(hashedPwd string) bool {
+	return passwordHasher{}.needsRehash(hashedPwd)
+}
+
+// pbkdf2SHA256 derives a key with PBKDF2 (RFC 8018) using HMAC-SHA256 as the
+// pseudorandom function.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	return pbkdf2.Key(password, salt, iterations, keyLength, sha256.New)
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// Test vectors from RFC 7914, section 11.
+	tests := []struct {
+		password, salt string
+		iterations     int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, 64))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %v, want %v", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+// legacyHash builds a hash in the original SHA-256 format.
+func legacyHash(t *testing.T, password string) string {
+	t.Helper()
+	salt := make([]byte, legacySaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hash := sha256.Sum256(append([]byte(password), salt...))
+	return hex.EncodeToString(append(salt, hash[:]...))
+}
+
+func TestPasswordFormats(t *testing.T) {
+	legacy := legacyHash(t, "secret")
+	if err := ComparePasswords(legacy, "secret"); err != nil {
+		t.Errorf("expected legacy hash to verify, got %v", err)
+	}
+	if err := ComparePasswords(legacy, "wrong"); err == nil {
+		t.Errorf("expected legacy hash to reject wrong password")
+	}
+	if !NeedsRehash(legacy) {
+		t.Errorf("expected legacy hash to need a rehash")
+	}
+
+	current := HashAndSalt("secret")
+	if NeedsRehash(current) {
+		t.Errorf("expected current hash not to need a rehash")
+	}
+	weaker := encodePasswordHash(defaultPasswordIterations-1, []byte("salt"), pbkdf2SHA256([]byte("secret"), []byte("salt"), defaultPasswordIterations-1, passwordKeyLength))
+	if err := ComparePasswords(weaker, "secret"); err != nil {
+		t.Errorf("expected hash with other iterations to verify, got %v", err)
+	}
+	if !NeedsRehash(weaker) {
+		t.Errorf("expected hash with fewer iterations to need a rehash")
+	}
+
+	for _, invalid := range []string{
+		"",
+		"$pbkdf2-sha256$i=0$c2FsdA$aGFzaA",
+		"$pbkdf2-sha256$i=abc$c2FsdA$aGFzaA",
+		"$pbkdf2-sha256$rounds=1000$c2FsdA$aGFzaA",
+		"$pbkdf2-sha256$i=1000$c2FsdA$",
+		"$bcrypt$i=1000$c2FsdA$aGFzaA",
+		strings.Repeat("zz", 48),
+	} {
+		if err := ComparePasswords(invalid, "secret"); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestLegacyPasswordUpgrade(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := createTestUser(t, store, "legacy")
+		legacy := legacyHash(t, user.Password)
+		switch s := store.(type) {
+		case *SQLStore:
+			if _, err := s.db.Exec(`UPDATE users SET "password" = $1 WHERE "id" = $2`, legacy, user.ID); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		case *MemoryStore:
+			for i := range s.users {
+				if s.users[i].ID == user.ID {
+					s.users[i].Password = legacy
+				}
+			}
+		}
+
+		wrong := user
+		wrong.Password = "wrong"
+		if err := store.UserExists(ctx, wrong); err == nil {
+			t.Fatalf("expected wrong password to be rejected")
+		}
+		if stored := storedPassword(t, store, user.ID); stored != legacy {
+			t.Errorf("expected failed login to keep the legacy hash, got %v", stored)
+		}
+
+		if err := store.UserExists(ctx, user); err != nil {
+			t.Fatalf("expected legacy password to log in, got %v", err)
+		}
+		stored := storedPassword(t, store, user.ID)
+		if !strings.HasPrefix(stored, "$pbkdf2-sha256$") {
+			t.Errorf("expected hash to be upgraded, got %v", stored)
+		}
+		if err := store.UserExists(ctx, user); err != nil {
+			t.Errorf("expected upgraded password to log in, got %v", err)
+		}
+	})
+}
+
+func TestStorePasswordIterations(t *testing.T) {
+	store, err := Open("memory://", Options{PasswordIterations: 1234})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user := createTestUser(t, store, "iterations")
+	if stored := storedPassword(t, store, user.ID); !strings.HasPrefix(stored, "$pbkdf2-sha256$i=1234$") {
+		t.Errorf("expected the configured work factor, got %v", stored)
+	}
+	if (passwordHasher{}).work() != defaultPasswordIterations {
+		t.Errorf("expected the default work factor when none is configured")
+	}
+}
+
+func TestMemoryConcurrentUserCreate(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMemoryStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.UserCreate(ctx, User{Username: "racer", Email: "racer@example.com", Password: "secret"})
+		}()
+	}
+	wg.Wait()
+	if users, _ := store.UserList(ctx); len(users) != 1 {
+		t.Errorf("expected one user, got %v", len(users))
+	}
+	if err := store.UserExists(ctx, User{Username: "racer", Password: "secret"}); err != nil {
+		t.Errorf("expected the password to log in, got %v", err)
+	}
+}
+
+func storedPassword(t *testing.T, store Store, id uint) string {
+	t.Helper()
+	switch s := store.(type) {
+	case *SQLStore:
+		var password string
+		if err := s.db.QueryRow(`SELECT "password" FROM users WHERE "id" = $1`, id).Scan(&password); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return password
+	case *MemoryStore:
+		for _, u := range s.users {
+			if u.ID == id {
+				return u.Password
+			}
+		}
+	}
+	t.Fatalf("user %d not found", id)
+	return ""
+}
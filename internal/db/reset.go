@@ -0,0 +1,44 @@
+package db
+
+import "context"
+
+func (s *SQLStore) PasswordResetCreate(ctx context.Context, reset PasswordReset) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO password_resets ("hash", "user_id", "created", "expires")
+	VALUES ($1, $2, $3, $4)`,
+		reset.Hash, reset.UserID, reset.Created, reset.Expires)
+	return wrapError("create password reset", err)
+}
+
+// PasswordResetUse marks a reset token as used and returns it, like
+// RefreshTokenUse: Used is set on the result if it had already been used.
+func (s *SQLStore) PasswordResetUse(ctx context.Context, hash string) (PasswordReset, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var reset PasswordReset
+	err := s.db.QueryRowContext(ctx, `
+	SELECT "hash", "user_id", "created", "expires", "used"
+		FROM password_resets
+		WHERE "hash" = $1`, hash).Scan(&reset.Hash, &reset.UserID,
+		&reset.Created, &reset.Expires, &reset.Used)
+	if err != nil {
+		return PasswordReset{}, wrapError("use password reset", err)
+	}
+	if reset.Used {
+		return reset, nil
+	}
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE password_resets SET "used" = TRUE
+		WHERE "hash" = $1 AND "used" = FALSE`, hash)
+	if err != nil {
+		return PasswordReset{}, wrapError("use password reset", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return PasswordReset{}, wrapError("use password reset", err)
+	} else if n == 0 {
+		reset.Used = true
+	}
+	return reset, nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPasswordResets(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := createTestUser(t, store, "resetter")
+		reset := PasswordReset{Hash: "reset-1", UserID: user.ID, Created: 100, Expires: 200}
+		if err := store.PasswordResetCreate(ctx, reset); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := store.PasswordResetCreate(ctx, reset); err == nil {
+			t.Errorf("expected error for duplicate reset, got nil")
+		}
+
+		used, err := store.PasswordResetUse(ctx, "reset-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if used != reset {
+			t.Errorf("expected %+v, got %+v", reset, used)
+		}
+		if again, _ := store.PasswordResetUse(ctx, "reset-1"); !again.Used {
+			t.Errorf("expected second use to report reuse")
+		}
+		if _, err := store.PasswordResetUse(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v, got %v", ErrNotFound, err)
+		}
+
+		if updated, err := store.UserSetPassword(ctx, user.ID, "changed"); err != nil || updated != 1 {
+			t.Fatalf("expected 1 updated user, got %d (%v)", updated, err)
+		}
+		if err := store.UserExists(ctx, user); err == nil {
+			t.Errorf("expected old password to be rejected")
+		}
+		user.Password = "changed"
+		if err := store.UserExists(ctx, user); err != nil {
+			t.Errorf("expected new password to log in, got %v", err)
+		}
+		if updated, _ := store.UserSetPassword(ctx, user.ID+1000, "changed"); updated != 0 {
+			t.Errorf("expected unknown user not to be updated, got %d", updated)
+		}
+	})
+}
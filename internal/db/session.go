@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+func (s *SQLStore) SessionCreate(ctx context.Context, session Session) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO sessions ("id", "user_id", "system_name", "created", "expires")
+	VALUES ($1, $2, $3, $4, $5)`,
+		session.ID, session.UserID, session.SystemName, session.Created, session.Expires)
+	return wrapError("create session", err)
+}
+
+// SessionGet returns the session with the given token id, revoked or not, or
+// ErrNotFound if no such token was issued.
+func (s *SQLStore) SessionGet(ctx context.Context, id string) (Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var session Session
+	err := s.db.QueryRowContext(ctx, `
+	SELECT "id", "user_id", "system_name", "created", "expires", "revoked"
+		FROM sessions
+		WHERE "id" = $1`, id).Scan(&session.ID, &session.UserID, &session.SystemName,
+		&session.Created, &session.Expires, &session.Revoked)
+	if err != nil {
+		return Session{}, wrapError("get session", err)
+	}
+	return session, nil
+}
+
+// SessionList returns the user's sessions that are neither revoked nor
+// expired, newest first.
+func (s *SQLStore) SessionList(ctx context.Context, userID uint) ([]Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `
+	SELECT "id", "user_id", "system_name", "created", "expires"
+		FROM sessions
+		WHERE "user_id" = $1 AND "revoked" = FALSE AND "expires" > $2
+		ORDER BY "created" DESC`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, wrapError("list sessions", err)
+	}
+	defer rows.Close()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(&session.ID, &session.UserID, &session.SystemName, &session.Created, &session.Expires)
+		if err != nil {
+			return nil, wrapError("list sessions", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, wrapError("list sessions", rows.Err())
+}
+
+func (s *SQLStore) SessionRevoke(ctx context.Context, userID uint, id string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE sessions SET "revoked" = TRUE
+		WHERE "user_id" = $1 AND "id" = $2 AND "revoked" = FALSE`, userID, id)
+	if err != nil {
+		return 0, wrapError("revoke session", err)
+	}
+	return affected("revoke session", res)
+}
+
+func (s *SQLStore) SessionRevokeAll(ctx context.Context, userID uint) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE sessions SET "revoked" = TRUE
+		WHERE "user_id" = $1 AND "revoked" = FALSE`, userID)
+	if err != nil {
+		return 0, wrapError("revoke sessions", err)
+	}
+	return affected("revoke sessions", res)
+}
+
+// SessionRevokeOthers revokes every session of the user except keep, for
+// instance after a password change made from that session.
+func (s *SQLStore) SessionRevokeOthers(ctx context.Context, userID uint, keep string) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE sessions SET "revoked" = TRUE
+		WHERE "user_id" = $1 AND "id" <> $2 AND "revoked" = FALSE`, userID, keep)
+	if err != nil {
+		return 0, wrapError("revoke other sessions", err)
+	}
+	return affected("revoke other sessions", res)
+}
+
+// SessionExtend moves a session's expiry forward, for instance when a refresh
+// token is rotated. It never shortens a session.
+func (s *SQLStore) SessionExtend(ctx context.Context, id string, expires int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+	UPDATE sessions SET "expires" = $2
+		WHERE "id" = $1 AND "expires" < $2`, id, expires)
+	return wrapError("extend session", err)
+}
+
+func (s *SQLStore) RefreshTokenCreate(ctx context.Context, token RefreshToken) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	_, err := s.db.ExecContext(ctx, `
+	INSERT INTO refresh_tokens ("hash", "session_id", "created", "expires")
+	VALUES ($1, $2, $3, $4)`,
+		token.Hash, token.SessionID, token.Created, token.Expires)
+	return wrapError("create refresh token", err)
+}
+
+// RefreshTokenUse marks a refresh token as used and returns it. Used is set on
+// the result if the token had already been used, which signals reuse. It
+// returns ErrNotFound for unknown tokens.
+func (s *SQLStore) RefreshTokenUse(ctx context.Context, hash string) (RefreshToken, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var token RefreshToken
+	err := s.db.QueryRowContext(ctx, `
+	SELECT "hash", "session_id", "created", "expires", "used"
+		FROM refresh_tokens
+		WHERE "hash" = $1`, hash).Scan(&token.Hash, &token.SessionID,
+		&token.Created, &token.Expires, &token.Used)
+	if err != nil {
+		return RefreshToken{}, wrapError("use refresh token", err)
+	}
+	if token.Used {
+		return token, nil
+	}
+	res, err := s.db.ExecContext(ctx, `
+	UPDATE refresh_tokens SET "used" = TRUE
+		WHERE "hash" = $1 AND "used" = FALSE`, hash)
+	if err != nil {
+		return RefreshToken{}, wrapError("use refresh token", err)
+	}
+	// A concurrent request may have used the token between the two queries.
+	if n, err := res.RowsAffected(); err != nil {
+		return RefreshToken{}, wrapError("use refresh token", err)
+	} else if n == 0 {
+		token.Used = true
+	}
+	return token, nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := createTestUser(t, store, "sessions")
+		other := createTestUser(t, store, "sessions-other")
+		now := time.Now().Unix()
+		sessions := []Session{
+			{ID: "session-1", UserID: user.ID, SystemName: "laptop", Created: now - 20, Expires: now + 3600},
+			{ID: "session-2", UserID: user.ID, SystemName: "desktop", Created: now - 10, Expires: now + 3600},
+			{ID: "session-expired", UserID: user.ID, SystemName: "old", Created: now - 7200, Expires: now - 3600},
+			{ID: "session-other", UserID: other.ID, SystemName: "laptop", Created: now, Expires: now + 3600},
+		}
+		for _, session := range sessions {
+			if err := store.SessionCreate(ctx, session); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if err := store.SessionCreate(ctx, sessions[0]); err == nil {
+			t.Errorf("expected error for duplicate session id, got nil")
+		}
+
+		got, err := store.SessionGet(ctx, "session-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.UserID != user.ID || got.SystemName != "laptop" || got.Revoked {
+			t.Errorf("unexpected session %+v", got)
+		}
+		if _, err := store.SessionGet(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v, got %v", ErrNotFound, err)
+		}
+
+		list, err := store.SessionList(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(list) != 2 || list[0].ID != "session-2" || list[1].ID != "session-1" {
+			t.Errorf("expected active sessions newest first, got %+v", list)
+		}
+
+		if revoked, _ := store.SessionRevoke(ctx, user.ID, "session-other"); revoked != 0 {
+			t.Errorf("expected other user's session to be untouched, got %d", revoked)
+		}
+		if revoked, _ := store.SessionRevoke(ctx, user.ID, "session-1"); revoked != 1 {
+			t.Errorf("expected 1 revoked session, got %d", revoked)
+		}
+		if got, _ := store.SessionGet(ctx, "session-1"); !got.Revoked {
+			t.Errorf("expected session to be revoked")
+		}
+		if revoked, _ := store.SessionRevokeAll(ctx, user.ID); revoked != 2 {
+			t.Errorf("expected 2 revoked sessions, got %d", revoked)
+		}
+		if list, _ := store.SessionList(ctx, user.ID); len(list) != 0 {
+			t.Errorf("expected no active sessions, got %+v", list)
+		}
+		if got, _ := store.SessionGet(ctx, "session-other"); got.Revoked {
+			t.Errorf("expected other user's session to stay active")
+		}
+	})
+}
+
+func TestRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := createTestUser(t, store, "refresh")
+		now := time.Now().Unix()
+		session := Session{ID: "refresh-session", UserID: user.ID, Created: now, Expires: now + 60}
+		if err := store.SessionCreate(ctx, session); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		token := RefreshToken{Hash: "hash-1", SessionID: session.ID, Created: now, Expires: now + 3600}
+		if err := store.RefreshTokenCreate(ctx, token); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := store.RefreshTokenCreate(ctx, token); err == nil {
+			t.Errorf("expected error for duplicate refresh token, got nil")
+		}
+
+		used, err := store.RefreshTokenUse(ctx, "hash-1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if used.Used || used.SessionID != session.ID || used.Expires != token.Expires {
+			t.Errorf("unexpected refresh token %+v", used)
+		}
+		if reused, _ := store.RefreshTokenUse(ctx, "hash-1"); !reused.Used {
+			t.Errorf("expected second use to report reuse")
+		}
+		if _, err := store.RefreshTokenUse(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected %v, got %v", ErrNotFound, err)
+		}
+
+		if err := store.SessionExtend(ctx, session.ID, now+3600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		store.SessionExtend(ctx, session.ID, now+30)
+		if got, _ := store.SessionGet(ctx, session.ID); got.Expires != now+3600 {
+			t.Errorf("expected expiry %d, got %d", now+3600, got.Expires)
+		}
+	})
+}
+
+func TestSessionRevokeOthers(t *testing.T) {
+	ctx := context.Background()
+	forEachStore(t, func(t *testing.T, store Store) {
+		user := createTestUser(t, store, "revoke-others")
+		now := time.Now().Unix()
+		for _, id := range []string{"others-1", "others-2", "others-3"} {
+			if err := store.SessionCreate(ctx, Session{ID: id, UserID: user.ID, Created: now, Expires: now + 60}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if revoked, err := store.SessionRevokeOthers(ctx, user.ID, "others-2"); err != nil || revoked != 2 {
+			t.Errorf("expected 2 revoked sessions, got %d (%v)", revoked, err)
+		}
+		if list, _ := store.SessionList(ctx, user.ID); len(list) != 1 || list[0].ID != "others-2" {
+			t.Errorf("expected only the kept session, got %+v", list)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"container/list"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"modernc.org/sqlite"
+)
+
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+	dialectMemory   = "memory"
+)
+
+// regexpCacheSize bounds how many compiled search patterns are kept.
+const regexpCacheSize = 64
+
+// regexpCache holds recently used search patterns so the REGEXP function does
+// not recompile the same pattern for every row it is evaluated against. It is
+// bounded so the patterns users search for cannot grow it without limit.
+var regexpCache = &patternCache{entries: map[string]*list.Element{}, order: list.New()}
+
+// patternCache is a least recently used cache of compiled patterns.
+type patternCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func (c *patternCache) get(pattern string) (*regexp.Regexp, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[pattern]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*regexp.Regexp), true
+}
+
+func (c *patternCache) add(pattern string, re *regexp.Regexp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[pattern]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[pattern] = c.order.PushFront(re)
+	if c.order.Len() > regexpCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*regexp.Regexp).String())
+	}
+}
+
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp)
+}
+
+// sqliteRegexp implements "X REGEXP Y", which SQLite evaluates as
+// regexp(Y, X), giving search the same regex support as Postgres' ~ operator.
+func sqliteRegexp(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("regexp: pattern must be text")
+	}
+	var value string
+	switch v := args[1].(type) {
+	case nil:
+		return false, nil
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		value = fmt.Sprint(v)
+	}
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(value), nil
+}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexpCache.get(pattern); ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.add(pattern, re)
+	return re, nil
+}
+
+// dialectOf reports which backend a -db value refers to: memory:// is the
+// in-process store, postgres URLs and lib/pq key=value connection strings use
+// Postgres, and anything else is treated as a SQLite database file. A value
+// that starts like a key=value string with a key lib/pq does not know is
+// rejected rather than guessed at; sqlite:// or file: forces a SQLite path.
+func dialectOf(dbPath string) (string, error) {
+	switch {
+	case strings.HasPrefix(dbPath, "memory://"):
+		return dialectMemory, nil
+	case strings.HasPrefix(dbPath, "postgres://"), strings.HasPrefix(dbPath, "postgresql://"):
+		return dialectPostgres, nil
+	case strings.HasPrefix(dbPath, "sqlite://"), strings.HasPrefix(dbPath, "file:"):
+		return dialectSQLite, nil
+	}
+	fields := strings.Fields(dbPath)
+	if len(fields) == 0 {
+		return dialectSQLite, nil
+	}
+	key, _, ok := strings.Cut(fields[0], "=")
+	if !ok || !isDSNKey(key) {
+		return dialectSQLite, nil
+	}
+	if pqKeys[key] {
+		return dialectPostgres, nil
+	}
+	return "", fmt.Errorf("db %q is neither a postgres connection string nor a file path, prefix SQLite paths with sqlite://", dbPath)
+}
+
+// pqKeys are the connection parameters lib/pq accepts in key=value strings.
+var pqKeys = map[string]bool{
+	"host": true, "hostaddr": true, "port": true, "dbname": true, "user": true,
+	"password": true, "passfile": true, "sslmode": true, "sslcert": true,
+	"sslkey": true, "sslrootcert": true, "sslinline": true, "sslsni": true,
+	"connect_timeout": true, "application_name": true,
+	"fallback_application_name": true, "options": true, "client_encoding": true,
+	"datestyle": true, "krbsrvname": true, "krbspn": true,
+}
+
+// isDSNKey reports whether s could be a connection parameter name, as opposed
+// to the start of a file path.
+func isDSNKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// sqliteDSN turns a file path (optionally prefixed with sqlite:// or file:)
+// into a modernc.org/sqlite DSN with foreign keys, WAL and a busy timeout.
+func sqliteDSN(dbPath string) string {
+	path := strings.TrimPrefix(dbPath, "sqlite://")
+	path = strings.TrimPrefix(path, "file:")
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return "file:" + path + sep +
+		"_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
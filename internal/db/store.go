@@ -0,0 +1,71 @@
+package db
+
+import "context"
+
+// Store is the persistence layer used by the HTTP server. SQLStore provides it
+// on top of Postgres or SQLite. Every call takes the context of the request it
+// serves, so a disconnecting client cancels its running queries.
+type Store interface {
+	UserExists(ctx context.Context, user User) error
+	UserGetID(ctx context.Context, user User) (uint, error)
+	UserGetByID(ctx context.Context, id uint) (User, error)
+	UserGetSystemName(ctx context.Context, user User) (string, error)
+	UsernameExists(ctx context.Context, user User) (bool, error)
+	EmailExists(ctx context.Context, user User) (bool, error)
+	UserCreate(ctx context.Context, user User) (int64, error)
+	UserSetPassword(ctx context.Context, id uint, password string) (int64, error)
+	UserList(ctx context.Context) ([]User, error)
+	UserCount(ctx context.Context) (int, error)
+	UserSetAdmin(ctx context.Context, id uint, admin bool) (int64, error)
+	UserSetDisabled(ctx context.Context, id uint, disabled bool) (int64, error)
+	UserDelete(ctx context.Context, id uint) (int64, error)
+
+	SystemInsert(ctx context.Context, sys System) (int64, error)
+	SystemUpdate(ctx context.Context, sys System) (int64, error)
+	SystemGet(ctx context.Context, sys System) (System, error)
+
+	CommandInsert(ctx context.Context, cmd Command) (int64, error)
+	CommandGet(ctx context.Context, cmd Command) ([]Query, error)
+	CommandGetUUID(ctx context.Context, cmd Command) (Query, error)
+	CommandDelete(ctx context.Context, cmd Command) (int64, error)
+
+	StatusGet(ctx context.Context, status Status) (Status, error)
+	ImportCommands(ctx context.Context, imp Import) error
+
+	SessionCreate(ctx context.Context, session Session) error
+	SessionGet(ctx context.Context, id string) (Session, error)
+	SessionList(ctx context.Context, userID uint) ([]Session, error)
+	SessionRevoke(ctx context.Context, userID uint, id string) (int64, error)
+	SessionRevokeAll(ctx context.Context, userID uint) (int64, error)
+	SessionRevokeOthers(ctx context.Context, userID uint, keep string) (int64, error)
+	SessionExtend(ctx context.Context, id string, expires int64) error
+
+	RefreshTokenCreate(ctx context.Context, token RefreshToken) error
+	RefreshTokenUse(ctx context.Context, hash string) (RefreshToken, error)
+
+	APIKeyCreate(ctx context.Context, key APIKey) error
+	APIKeyGet(ctx context.Context, userID uint, id string) (APIKey, error)
+	APIKeyGetByHash(ctx context.Context, hash string) (APIKey, error)
+	APIKeyList(ctx context.Context, userID uint) ([]APIKey, error)
+	APIKeyUpdate(ctx context.Context, key APIKey) (int64, error)
+	APIKeyTouch(ctx context.Context, id string, lastUsed int64) error
+	APIKeyDelete(ctx context.Context, userID uint, id string) (int64, error)
+
+	PasswordResetCreate(ctx context.Context, reset PasswordReset) error
+	PasswordResetUse(ctx context.Context, hash string) (PasswordReset, error)
+
+	InviteCreate(ctx context.Context, invite Invite) error
+	InviteList(ctx context.Context) ([]Invite, error)
+	InviteRevoke(ctx context.Context, id string) (int64, error)
+	InviteRedeem(ctx context.Context, hash string, now int64) (Invite, error)
+	InviteRelease(ctx context.Context, id string) error
+
+	Stats(ctx context.Context) (Stats, error)
+
+	GetSecret(ctx context.Context) (string, error)
+	GetSecrets(ctx context.Context) ([]Secret, error)
+	RotateSecret(ctx context.Context) (Secret, error)
+	Close() error
+}
+
+var _ Store = (*SQLStore)(nil)
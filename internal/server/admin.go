@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// isAdmin reports whether user may perform administrative actions. The flag
+// is read from the store on every call so promotions and demotions take
+// effect immediately.
+func (s *Server) isAdmin(ctx context.Context, user *db.User) (bool, error) {
+	stored, err := s.store.UserGetByID(ctx, user.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return stored.IsAdmin && !stored.Disabled, nil
+}
+
+// adminMiddleware authenticates like authMiddleware and then requires the
+// caller to be an administrator. API keys are refused, so a leaked key cannot
+// be used to take over other accounts.
+func (s *Server) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("apiKey").(string); ok {
+			s.respondError(w, r, http.StatusForbidden, "API keys cannot be used for administration")
+			return
+		}
+		user := r.Context().Value("user").(*db.User)
+		admin, err := s.isAdmin(r.Context(), user)
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to check administrator access")
+			return
+		}
+		if !admin {
+			s.respondError(w, r, http.StatusForbidden, "Administrator access required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// bootstrapAdmins promotes the users named in Options.Admins, so a fresh
+// deployment has someone able to use the administrative endpoints.
+func (s *Server) bootstrapAdmins(ctx context.Context) error {
+	for _, username := range s.config().Admins {
+		id, err := s.store.UserGetID(ctx, db.User{Username: username})
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return fmt.Errorf("failed to look up %q: %w", username, err)
+		}
+		if err != nil || id == 0 {
+			s.log.Warn("admin does not exist yet, skipping", "username", username)
+			continue
+		}
+		if _, err := s.store.UserSetAdmin(ctx, id, true); err != nil {
+			return fmt.Errorf("failed to promote %q: %w", username, err)
+		}
+	}
+	return nil
+}
+
+// promoteFirstUser makes the only registered user an administrator when
+// Options.FirstUserAdmin is set.
+func (s *Server) promoteFirstUser(ctx context.Context, user db.User) {
+	if !s.config().FirstUserAdmin {
+		return
+	}
+	if count, err := s.store.UserCount(ctx); err != nil || count != 1 {
+		return
+	}
+	id, err := s.store.UserGetID(ctx, user)
+	if err != nil {
+		return
+	}
+	s.store.UserSetAdmin(ctx, id, true)
+}
+
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	users, err := s.store.UserList(r.Context())
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to list users")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// handleAdminUser serves /api/v1/admin/users/{id} (PATCH, DELETE) and
+// /api/v1/admin/users/{id}/logout (POST). Administrators cannot demote,
+// disable or delete themselves, so at least one always remains.
+func (s *Server) handleAdminUser(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value("user").(*db.User)
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/users/")
+	path, logout := strings.CutSuffix(path, "/logout")
+	id, err := strconv.ParseUint(path, 10, 64)
+	if err != nil || id == 0 {
+		s.respondError(w, r, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	target, err := s.store.UserGetByID(r.Context(), uint(id))
+	if errors.Is(err, db.ErrNotFound) {
+		s.respondError(w, r, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		s.respondStoreError(w, r, err, "Failed to get user")
+		return
+	}
+
+	switch {
+	case logout && r.Method == http.MethodPost:
+		revoked, err := s.store.SessionRevokeAll(r.Context(), target.ID)
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to revoke sessions")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+	case !logout && r.Method == http.MethodPatch:
+		var body struct {
+			IsAdmin  *bool `json:"isAdmin"`
+			Disabled *bool `json:"disabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.respondError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if target.ID == admin.ID && ((body.IsAdmin != nil && !*body.IsAdmin) || (body.Disabled != nil && *body.Disabled)) {
+			s.respondError(w, r, http.StatusBadRequest, "Administrators cannot demote or disable themselves")
+			return
+		}
+		if body.IsAdmin != nil {
+			if _, err := s.store.UserSetAdmin(r.Context(), target.ID, *body.IsAdmin); err != nil {
+				s.respondStoreError(w, r, err, "Failed to update user")
+				return
+			}
+			target.IsAdmin = *body.IsAdmin
+		}
+		if body.Disabled != nil {
+			if _, err := s.store.UserSetDisabled(r.Context(), target.ID, *body.Disabled); err != nil {
+				s.respondStoreError(w, r, err, "Failed to update user")
+				return
+			}
+			// Disabling ends every session; API keys are refused while
+			// the account stays disabled.
+			if *body.Disabled {
+				if _, err := s.store.SessionRevokeAll(r.Context(), target.ID); err != nil {
+					s.respondStoreError(w, r, err, "Failed to revoke sessions")
+					return
+				}
+			}
+			target.Disabled = *body.Disabled
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(target)
+	case !logout && r.Method == http.MethodDelete:
+		if target.ID == admin.ID {
+			s.respondError(w, r, http.StatusBadRequest, "Administrators cannot delete themselves")
+			return
+		}
+		if _, err := s.store.UserDelete(r.Context(), target.ID); err != nil {
+			s.respondStoreError(w, r, err, "Failed to delete user")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
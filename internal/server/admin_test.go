@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// promote makes an existing user an administrator.
+func promote(t *testing.T, username string) uint {
+	t.Helper()
+	ctx := context.Background()
+	id, err := testStore.UserGetID(ctx, db.User{Username: username})
+	if err != nil || id == 0 {
+		t.Fatalf("expected user id, got %v (%v)", id, err)
+	}
+	if _, err := testStore.UserSetAdmin(ctx, id, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return id
+}
+
+func TestBootstrapAdmins(t *testing.T) {
+	ctx := context.Background()
+	createUser(t, "bootstrap-admin")
+	server := newTestServer(t, Options{Registration: true, Admins: []string{"bootstrap-admin", "bootstrap-missing"}})
+	id, _ := testStore.UserGetID(ctx, db.User{Username: "bootstrap-admin"})
+	if user, _ := testStore.UserGetByID(ctx, id); !user.IsAdmin {
+		t.Errorf("expected bootstrap-admin to be promoted, got %+v", user)
+	}
+	admin := registerAndLogin(t, server, "bootstrap-other")
+	if w := doRequest(server, "GET", "/api/v1/admin/users", admin, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v for non-admin, got %v", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestFirstUserAdmin(t *testing.T) {
+	ctx := context.Background()
+	// Promotion depends on the user count, so start from an empty store.
+	store := db.NewMemoryStore()
+	server, err := NewServer(store, Options{LogFile: "/dev/null", Registration: true, FirstUserAdmin: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	register(server, "first-user", "first-user@example.com", nil)
+	register(server, "second-user", "second-user@example.com", nil)
+
+	users, _ := store.UserList(ctx)
+	if len(users) != 2 || !users[0].IsAdmin || users[1].IsAdmin {
+		t.Errorf("expected only the first user to be admin, got %+v", users)
+	}
+}
+
+func TestAdminRejectsAPIKeys(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	admin := registerAndLogin(t, server, "keyed-admin")
+	promote(t, "keyed-admin")
+	key := createAPIKey(t, server, admin, map[string]interface{}{"name": "leaked", "scope": db.ScopeReadWrite})
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/api/v1/admin/users"},
+		{"PATCH", "/api/v1/admin/users/1"},
+		{"DELETE", "/api/v1/admin/users/1"},
+		{"POST", "/api/v1/admin/password-resets"},
+		{"GET", "/api/v1/admin/invites"},
+		{"DELETE", "/api/v1/admin/invites/1"},
+	} {
+		w := doRequest(server, req.method, req.path, key.Key, map[string]interface{}{"username": "keyed-admin"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected %v for an API key, got %v", req.method, req.path, http.StatusForbidden, w.Code)
+		}
+	}
+	if w := doRequest(server, "GET", "/api/v1/admin/users", admin, nil); w.Code != http.StatusOK {
+		t.Errorf("expected %v for the admin's session, got %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestAdminUserEndpoints(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, Options{Registration: true})
+	admin := registerAndLogin(t, server, "users-admin")
+	adminID := promote(t, "users-admin")
+	member := registerAndLogin(t, server, "users-member")
+	memberID, _ := testStore.UserGetID(ctx, db.User{Username: "users-member"})
+	memberPath := "/api/v1/admin/users/" + itoa(int64(memberID))
+	adminPath := "/api/v1/admin/users/" + itoa(int64(adminID))
+
+	if w := doRequest(server, "GET", "/api/v1/admin/users", member, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v for non-admin, got %v", http.StatusForbidden, w.Code)
+	}
+	w := doRequest(server, "GET", "/api/v1/admin/users", admin, nil)
+	var users []db.User
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &users) != nil {
+		t.Fatalf("expected user list, got %v %s", w.Code, w.Body.String())
+	}
+	listed := map[string]db.User{}
+	for _, u := range users {
+		listed[u.Username] = u
+	}
+	if !listed["users-admin"].IsAdmin || listed["users-member"].IsAdmin || listed["users-member"].Password != "" {
+		t.Errorf("unexpected user list %+v", users)
+	}
+
+	for _, body := range []map[string]bool{{"isAdmin": false}, {"disabled": true}} {
+		if w := doRequest(server, "PATCH", adminPath, admin, body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for self %v, got %v", http.StatusBadRequest, body, w.Code)
+		}
+	}
+	if w := doRequest(server, "DELETE", adminPath, admin, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for self delete, got %v", http.StatusBadRequest, w.Code)
+	}
+	if w := doRequest(server, "PATCH", "/api/v1/admin/users/999999", admin, map[string]bool{"isAdmin": true}); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v for unknown user, got %v", http.StatusNotFound, w.Code)
+	}
+	if w := doRequest(server, "PATCH", "/api/v1/admin/users/abc", admin, map[string]bool{"isAdmin": true}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for invalid id, got %v", http.StatusBadRequest, w.Code)
+	}
+
+	// Promotion takes effect on the member's existing token.
+	if w := doRequest(server, "PATCH", memberPath, admin, map[string]bool{"isAdmin": true}); w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := doRequest(server, "GET", "/api/v1/admin/users", member, nil); w.Code != http.StatusOK {
+		t.Errorf("expected promoted member to be admin, got %v", w.Code)
+	}
+	doRequest(server, "PATCH", memberPath, admin, map[string]bool{"isAdmin": false})
+
+	w = doRequest(server, "POST", memberPath+"/logout", admin, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, w.Code)
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", member, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v after forced logout, got %v", http.StatusUnauthorized, w.Code)
+	}
+
+	member = login(t, server, "users-member").AccessToken
+	key := createAPIKey(t, server, member, map[string]interface{}{"name": "cli", "scope": db.ScopeRead}).Key
+	w = doRequest(server, "PATCH", memberPath, admin, map[string]bool{"disabled": true})
+	var updated db.User
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &updated) != nil || !updated.Disabled {
+		t.Fatalf("expected disabled user, got %v %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", member, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v for disabled user's token, got %v", http.StatusUnauthorized, w.Code)
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", key, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v for disabled user's api key, got %v", http.StatusUnauthorized, w.Code)
+	}
+	if w := doRequest(server, "POST", "/api/v1/login", "", map[string]string{"username": "users-member", "password": "secret"}); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v for disabled login, got %v", http.StatusForbidden, w.Code)
+	}
+	doRequest(server, "PATCH", memberPath, admin, map[string]bool{"disabled": false})
+	if w := doRequest(server, "GET", "/api/v1/sessions", key, nil); w.Code != http.StatusOK {
+		t.Errorf("expected re-enabled user's api key to work, got %v", w.Code)
+	}
+
+	if w := doRequest(server, "DELETE", memberPath, admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, w.Code)
+	}
+	if w := doRequest(server, "DELETE", memberPath, admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v for deleted user, got %v", http.StatusNotFound, w.Code)
+	}
+	if w := doRequest(server, "POST", "/api/v1/login", "", map[string]string{"username": "users-member", "password": "secret"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v for deleted user login, got %v", http.StatusUnauthorized, w.Code)
+	}
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// apiKeyPrefix marks bearer credentials that are API keys rather than JWTs.
+const apiKeyPrefix = "bh_"
+
+func newAPIKey() (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + token, nil
+}
+
+// authenticateAPIKey looks up an API key and records its use. Expired keys
+// and keys whose owner no longer exists are rejected.
+func (s *Server) authenticateAPIKey(ctx context.Context, token string) (*db.User, db.APIKey, error) {
+	key, err := s.store.APIKeyGetByHash(ctx, hashToken(token))
+	if isStoreFailure(err) {
+		return nil, db.APIKey{}, err
+	} else if err != nil {
+		return nil, db.APIKey{}, fmt.Errorf("unknown api key")
+	}
+	now := time.Now().Unix()
+	if key.Expires != 0 && now > key.Expires {
+		return nil, db.APIKey{}, fmt.Errorf("api key expired")
+	}
+	user, err := s.store.UserGetByID(ctx, key.UserID)
+	if isStoreFailure(err) {
+		return nil, db.APIKey{}, err
+	} else if err != nil {
+		return nil, db.APIKey{}, fmt.Errorf("unknown api key owner")
+	}
+	if user.Disabled {
+		return nil, db.APIKey{}, fmt.Errorf("api key owner disabled")
+	}
+	s.store.APIKeyTouch(ctx, key.ID, now)
+	return &user, key, nil
+}
+
+// apiKeyRequest is the body accepted when creating or updating a key. Expires
+// can only be set when the key is created.
+type apiKeyRequest struct {
+	Name    *string `json:"name"`
+	Scope   *string `json:"scope"`
+	Expires *int64  `json:"expires"`
+}
+
+// validateAPIKey checks the user supplied fields of a new or updated key.
+// Expiry is only checked against the key's creation time, so existing keys
+// can still be renamed after they expire.
+func validateAPIKey(key db.APIKey) error {
+	switch {
+	case key.Name == "":
+		return fmt.Errorf("name required")
+	case len(key.Name) > 255:
+		return fmt.Errorf("name must be at most 255 characters")
+	case key.Scope != db.ScopeRead && key.Scope != db.ScopeReadWrite:
+		return fmt.Errorf("scope must be %s or %s", db.ScopeRead, db.ScopeReadWrite)
+	case key.Expires < 0 || (key.Expires != 0 && key.Expires <= key.Created):
+		return fmt.Errorf("expires must be in the future")
+	}
+	return nil
+}
+
+// rejectAPIKeyAuth stops API keys from managing API keys, so a leaked key
+// cannot be used to mint more.
+func (s *Server) rejectAPIKeyAuth(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := r.Context().Value("apiKey").(string); ok {
+		s.respondError(w, r, http.StatusForbidden, "API keys cannot be managed with an API key")
+		return true
+	}
+	return false
+}
+
+// handleAPIKeys lists the caller's API keys on GET and creates one on POST.
+// The key itself is only returned in the POST response.
+func (s *Server) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if s.rejectAPIKeyAuth(w, r) {
+		return
+	}
+	user := r.Context().Value("user").(*db.User)
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.store.APIKeyList(r.Context(), user.ID)
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to list API keys")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.respondError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		key := db.APIKey{UserID: user.ID, Scope: db.ScopeRead, Created: time.Now().Unix()}
+		if req.Expires != nil {
+			key.Expires = *req.Expires
+		}
+		if req.Name != nil {
+			key.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Scope != nil {
+			key.Scope = *req.Scope
+		}
+		if err := validateAPIKey(key); err != nil {
+			s.respondError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		plain, err := newAPIKey()
+		if err != nil {
+			s.respondError(w, r, http.StatusInternalServerError, "Failed to create API key")
+			return
+		}
+		if key.ID, err = newTokenID(); err != nil {
+			s.respondError(w, r, http.StatusInternalServerError, "Failed to create API key")
+			return
+		}
+		key.Hash = hashToken(plain)
+		if err := s.store.APIKeyCreate(r.Context(), key); err != nil {
+			s.respondStoreError(w, r, err, "Failed to create API key")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			db.APIKey
+			Key string `json:"key"`
+		}{key, plain})
+	default:
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleAPIKey shows, renames or rescopes, and deletes a single API key.
+func (s *Server) handleAPIKey(w http.ResponseWriter, r *http.Request) {
+	if s.rejectAPIKeyAuth(w, r) {
+		return
+	}
+	user := r.Context().Value("user").(*db.User)
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/keys/")
+	if id == "" {
+		s.respondError(w, r, http.StatusBadRequest, "key id required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		key, err := s.store.APIKeyGet(r.Context(), user.ID, id)
+		if errors.Is(err, db.ErrNotFound) {
+			s.respondError(w, r, http.StatusNotFound, "API key not found")
+			return
+		}
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to get API key")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(key)
+	case http.MethodPatch:
+		key, err := s.store.APIKeyGet(r.Context(), user.ID, id)
+		if errors.Is(err, db.ErrNotFound) {
+			s.respondError(w, r, http.StatusNotFound, "API key not found")
+			return
+		}
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to update API key")
+			return
+		}
+		var req apiKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.respondError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Expires != nil {
+			s.respondError(w, r, http.StatusBadRequest, "expires cannot be changed, create a new key instead")
+			return
+		}
+		if req.Name != nil {
+			key.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Scope != nil {
+			key.Scope = *req.Scope
+		}
+		if err := validateAPIKey(key); err != nil {
+			s.respondError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := s.store.APIKeyUpdate(r.Context(), key); err != nil {
+			s.respondStoreError(w, r, err, "Failed to update API key")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(key)
+	case http.MethodDelete:
+		deleted, err := s.store.APIKeyDelete(r.Context(), user.ID, id)
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to delete API key")
+			return
+		}
+		if deleted == 0 {
+			s.respondError(w, r, http.StatusNotFound, "API key not found")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+type createdAPIKey struct {
+	db.APIKey
+	Key string `json:"key"`
+}
+
+func createAPIKey(t *testing.T, server *Server, token string, body map[string]interface{}) createdAPIKey {
+	t.Helper()
+	w := doRequest(server, "POST", "/api/v1/keys", token, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %v, got %v: %v", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var key createdAPIKey
+	if err := json.Unmarshal(w.Body.Bytes(), &key); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return key
+}
+
+func TestAPIKeyEndpoints(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	token := registerAndLogin(t, server, "keyowner")
+	otherToken := registerAndLogin(t, server, "keyowner-other")
+
+	readKey := createAPIKey(t, server, token, map[string]interface{}{"name": "runbook"})
+	if !strings.HasPrefix(readKey.Key, apiKeyPrefix) || readKey.Scope != db.ScopeRead || readKey.ID == "" {
+		t.Fatalf("unexpected key %+v", readKey)
+	}
+	writeKey := createAPIKey(t, server, token, map[string]interface{}{
+		"name": "ci", "scope": db.ScopeReadWrite, "expires": time.Now().Add(time.Hour).Unix(),
+	})
+
+	t.Run("list hides secrets", func(t *testing.T) {
+		w := doRequest(server, "GET", "/api/v1/keys", token, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %v, got %v", http.StatusOK, w.Code)
+		}
+		if strings.Contains(w.Body.String(), readKey.Key) || strings.Contains(w.Body.String(), hashToken(readKey.Key)) {
+			t.Errorf("expected list not to expose keys, got %v", w.Body.String())
+		}
+		var keys []db.APIKey
+		json.Unmarshal(w.Body.Bytes(), &keys)
+		if len(keys) != 2 {
+			t.Errorf("expected 2 keys, got %+v", keys)
+		}
+	})
+	t.Run("read-only key", func(t *testing.T) {
+		w := doRequest(server, "GET", "/api/v1/command/search", readKey.Key, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+		w = doRequest(server, "POST", "/api/v1/command", readKey.Key, map[string]interface{}{
+			"uuid": "apikey-read", "command": "ls", "path": "/", "created": 1,
+		})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected %v, got %v", http.StatusForbidden, w.Code)
+		}
+		w = doRequest(server, "GET", "/api/v1/keys/"+readKey.ID, token, nil)
+		var key db.APIKey
+		json.Unmarshal(w.Body.Bytes(), &key)
+		if key.LastUsed == 0 {
+			t.Errorf("expected last used to be recorded, got %+v", key)
+		}
+	})
+	t.Run("read-write key", func(t *testing.T) {
+		w := doRequest(server, "POST", "/api/v1/command", writeKey.Key, map[string]interface{}{
+			"uuid": "apikey-write", "command": "ls", "path": "/", "created": 1,
+		})
+		if w.Code != http.StatusCreated {
+			t.Errorf("expected %v, got %v: %v", http.StatusCreated, w.Code, w.Body.String())
+		}
+		w = doRequest(server, "POST", "/api/v1/keys", writeKey.Key, map[string]interface{}{"name": "escalate"})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected API keys not to manage API keys, got %v", w.Code)
+		}
+	})
+	t.Run("update", func(t *testing.T) {
+		w := doRequest(server, "PATCH", "/api/v1/keys/"+readKey.ID, token, map[string]interface{}{"name": "renamed"})
+		var key db.APIKey
+		json.Unmarshal(w.Body.Bytes(), &key)
+		if w.Code != http.StatusOK || key.Name != "renamed" || key.Scope != db.ScopeRead {
+			t.Errorf("unexpected update response %v: %+v", w.Code, key)
+		}
+		w = doRequest(server, "PATCH", "/api/v1/keys/"+readKey.ID, token, map[string]interface{}{"scope": "admin"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v, got %v", http.StatusBadRequest, w.Code)
+		}
+		w = doRequest(server, "PATCH", "/api/v1/keys/"+readKey.ID, token, map[string]interface{}{"expires": time.Now().Add(time.Hour).Unix()})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v when changing expires, got %v", http.StatusBadRequest, w.Code)
+		}
+		if w := doRequest(server, "GET", "/api/v1/keys/"+readKey.ID, token, nil); !strings.Contains(w.Body.String(), `"expires":0`) {
+			t.Errorf("expected expires to be unchanged, got %v", w.Body.String())
+		}
+		w = doRequest(server, "PATCH", "/api/v1/keys/"+readKey.ID, otherToken, map[string]interface{}{"name": "stolen"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+	t.Run("invalid keys", func(t *testing.T) {
+		tests := []map[string]interface{}{
+			{},
+			{"name": "  "},
+			{"name": strings.Repeat("a", 256)},
+			{"name": "x", "scope": "admin"},
+			{"name": "x", "expires": time.Now().Add(-time.Hour).Unix()},
+		}
+		for _, body := range tests {
+			if w := doRequest(server, "POST", "/api/v1/keys", token, body); w.Code != http.StatusBadRequest {
+				t.Errorf("expected %v for %v, got %v", http.StatusBadRequest, body, w.Code)
+			}
+		}
+		if w := doRequest(server, "GET", "/api/v1/command/search", apiKeyPrefix+"unknown", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected %v, got %v", http.StatusUnauthorized, w.Code)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		if w := doRequest(server, "DELETE", "/api/v1/keys/"+writeKey.ID, otherToken, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected %v, got %v", http.StatusNotFound, w.Code)
+		}
+		if w := doRequest(server, "DELETE", "/api/v1/keys/"+writeKey.ID, token, nil); w.Code != http.StatusOK {
+			t.Errorf("expected %v, got %v", http.StatusOK, w.Code)
+		}
+		if w := doRequest(server, "GET", "/api/v1/command/search", writeKey.Key, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected deleted key to be rejected, got %v", w.Code)
+		}
+	})
+}
+
+func TestExpiredAPIKey(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, Options{})
+	user := createUser(t, "expiredkey")
+	plain, _ := newAPIKey()
+	err := testStore.APIKeyCreate(ctx, db.APIKey{
+		ID: "expired", UserID: user.ID, Name: "old", Hash: hashToken(plain), Scope: db.ScopeRead,
+		Created: time.Now().Add(-2 * time.Hour).Unix(), Expires: time.Now().Add(-time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w := doRequest(server, "GET", "/api/v1/command/search", plain, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected expired key to be rejected, got %v", w.Code)
+	}
+}
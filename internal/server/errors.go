@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// storeStatus maps the kind of a store error to an HTTP status.
+func storeStatus(err error) int {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, db.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, db.ErrInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, db.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// respondStoreError reports a failed store call with the status matching its
+// kind. message says what failed; values the database rejected also carry
+// its reason. Server side failures are logged instead of shown to clients.
+func (s *Server) respondStoreError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	// A canceled request has nobody left to answer.
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		s.requestLog(r).Debug("store call canceled", "method", r.Method, "path", r.URL.Path, "error", err)
+		return
+	}
+	status := storeStatus(err)
+	switch status {
+	case http.StatusBadRequest:
+		var dbErr *db.Error
+		if errors.As(err, &dbErr) && dbErr.Err != nil {
+			message += ": " + dbErr.Err.Error()
+		}
+	case http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", "5")
+		message = "Database unavailable, try again later"
+	}
+	// A request whose client went away fails its queries by design.
+	if status >= http.StatusInternalServerError && r.Context().Err() == nil {
+		s.requestLog(r).Error("store call failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+	s.respondError(w, r, status, message)
+}
+
+// isStoreFailure reports whether err is a store error other than a missing
+// row, i.e. one that says nothing about the credentials being checked.
+func isStoreFailure(err error) bool {
+	var dbErr *db.Error
+	return errors.As(err, &dbErr) && !errors.Is(err, db.ErrNotFound)
+}
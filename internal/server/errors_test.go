@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// failingStore is a MemoryStore whose command search and, optionally, session
+// lookup fail with a fixed error.
+type failingStore struct {
+	*db.MemoryStore
+	err      error
+	failAuth bool
+}
+
+func (f *failingStore) CommandGet(ctx context.Context, cmd db.Command) ([]db.Query, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.MemoryStore.CommandGet(ctx, cmd)
+}
+
+func (f *failingStore) SessionGet(ctx context.Context, id string) (db.Session, error) {
+	if f.failAuth {
+		return db.Session{}, f.err
+	}
+	return f.MemoryStore.SessionGet(ctx, id)
+}
+
+func TestStoreErrorResponses(t *testing.T) {
+	store := &failingStore{MemoryStore: db.NewMemoryStore()}
+	server, err := NewServer(store, Options{Registration: true, LogFile: "/dev/null"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token := registerAndLogin(t, server, "failing")
+
+	tests := []struct {
+		name     string
+		err      error
+		failAuth bool
+		status   int
+		message  string
+	}{
+		{"not found", &db.Error{Op: "search commands", Kind: db.ErrNotFound}, false, http.StatusNotFound, "Failed to search commands"},
+		{"conflict", &db.Error{Op: "search commands", Kind: db.ErrConflict}, false, http.StatusConflict, "Failed to search commands"},
+		{"invalid", &db.Error{Op: "search commands", Kind: db.ErrInvalid, Err: errors.New("bad pattern")}, false, http.StatusBadRequest, "Failed to search commands: bad pattern"},
+		{"unavailable", &db.Error{Op: "search commands", Kind: db.ErrUnavailable, Err: errors.New("connection refused")}, false, http.StatusServiceUnavailable, "Database unavailable, try again later"},
+		{"unexpected", errors.New("disk on fire"), false, http.StatusInternalServerError, "Failed to search commands"},
+		{"canceled", &db.Error{Op: "search commands", Err: context.Canceled}, false, http.StatusInternalServerError, "Failed to search commands"},
+		{"auth unavailable", &db.Error{Op: "get session", Kind: db.ErrUnavailable}, true, http.StatusServiceUnavailable, "Database unavailable, try again later"},
+		{"auth not found", &db.Error{Op: "get session", Kind: db.ErrNotFound}, true, http.StatusUnauthorized, "Invalid token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.err, store.failAuth = tt.err, tt.failAuth
+			w := doRequest(server, "GET", "/api/v1/command/search", token, nil)
+			if w.Code != tt.status {
+				t.Fatalf("expected %v, got %v: %v", tt.status, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.message) {
+				t.Errorf("expected message %q, got %v", tt.message, w.Body.String())
+			}
+			if retry := w.Header().Get("Retry-After"); (tt.status == http.StatusServiceUnavailable) != (retry != "") {
+				t.Errorf("unexpected Retry-After %q for status %v", retry, tt.status)
+			}
+			if strings.Contains(w.Body.String(), "disk on fire") || strings.Contains(w.Body.String(), "connection refused") {
+				t.Errorf("expected server side failure to stay out of the response, got %v", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	store := &failingStore{MemoryStore: db.NewMemoryStore()}
+	server, err := NewServer(store, Options{Registration: true, LogFile: "/dev/null"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token := registerAndLogin(t, server, "canceled")
+	store.err = &db.Error{Op: "search commands", Err: context.Canceled}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/api/v1/command/search", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no response for a canceled request, got %v: %v", w.Code, w.Body.String())
+	}
+}
+
+func TestInvalidSearchPattern(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	token := registerAndLogin(t, server, "badpattern")
+	w := doRequest(server, "GET", "/api/v1/command/search?query=%28", token, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v, got %v: %v", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// Registration modes, see Options.RegistrationMode.
+const (
+	registrationOpen   = "open"
+	registrationClosed = "closed"
+	registrationInvite = "invite"
+	registrationDomain = "domain"
+)
+
+// registrationMode resolves the effective registration mode from opts.
+func registrationMode(opts Options) (string, error) {
+	if !opts.Registration {
+		return registrationClosed, nil
+	}
+	switch opts.RegistrationMode {
+	case "", registrationOpen:
+		return registrationOpen, nil
+	case registrationClosed, registrationInvite:
+		return opts.RegistrationMode, nil
+	case registrationDomain:
+		if len(opts.RegistrationDomains) == 0 {
+			return "", fmt.Errorf("registration mode domain needs at least one allowed domain")
+		}
+		return registrationDomain, nil
+	}
+	return "", fmt.Errorf("unknown registration mode %q, expected open, closed, invite or domain", opts.RegistrationMode)
+}
+
+// checkRegistration enforces the registration mode on a new user. In invite
+// mode it redeems the user's registration code and records the invite it
+// belongs to in place of the code.
+func (s *Server) checkRegistration(ctx context.Context, user *db.User) (int, error) {
+	switch s.config().registration {
+	case registrationDomain:
+		at := strings.LastIndex(user.Email, "@")
+		if at < 0 {
+			return http.StatusBadRequest, fmt.Errorf("invalid email address")
+		}
+		domain := user.Email[at+1:]
+		for _, allowed := range s.config().RegistrationDomains {
+			if strings.EqualFold(domain, allowed) {
+				user.RegistrationCode = nil
+				return http.StatusOK, nil
+			}
+		}
+		return http.StatusForbidden, fmt.Errorf("registration is limited to approved email domains")
+	case registrationInvite:
+		if user.RegistrationCode == nil || *user.RegistrationCode == "" {
+			return http.StatusForbidden, fmt.Errorf("an invite code is required to register")
+		}
+		invite, err := s.store.InviteRedeem(ctx, hashToken(*user.RegistrationCode), time.Now().Unix())
+		if errors.Is(err, db.ErrNotFound) {
+			return http.StatusForbidden, fmt.Errorf("invalid or expired invite code")
+		}
+		if err != nil {
+			return storeStatus(err), fmt.Errorf("failed to check invite code")
+		}
+		user.RegistrationCode = &invite.ID
+		return http.StatusOK, nil
+	}
+	user.RegistrationCode = nil
+	return http.StatusOK, nil
+}
+
+// releaseInvite gives back the invite checkRegistration redeemed for user
+// when creating the user then failed, so the code is not used up.
+func (s *Server) releaseInvite(ctx context.Context, user db.User) {
+	if s.config().registration != registrationInvite || user.RegistrationCode == nil {
+		return
+	}
+	// The request may have been canceled, which should not cost the invite.
+	if err := s.store.InviteRelease(context.WithoutCancel(ctx), *user.RegistrationCode); err != nil {
+		s.log.Error("failed to release invite", "invite", *user.RegistrationCode, "error", err)
+	}
+}
+
+// handleInvites lists invites on GET and mints one on POST. The code is only
+// returned when it is minted.
+func (s *Server) handleInvites(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(*db.User)
+
+	switch r.Method {
+	case http.MethodGet:
+		invites, err := s.store.InviteList(r.Context())
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to list invites")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(invites)
+	case http.MethodPost:
+		var body struct {
+			MaxUses *int  `json:"maxUses"`
+			Expires int64 `json:"expires"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			s.respondError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		invite := db.Invite{CreatedBy: user.ID, MaxUses: 1, Created: time.Now().Unix(), Expires: body.Expires}
+		if body.MaxUses != nil {
+			invite.MaxUses = *body.MaxUses
+		}
+		if invite.MaxUses < 0 {
+			s.respondError(w, r, http.StatusBadRequest, "maxUses must not be negative")
+			return
+		}
+		if invite.Expires < 0 || (invite.Expires != 0 && invite.Expires <= invite.Created) {
+			s.respondError(w, r, http.StatusBadRequest, "expires must be in the future")
+			return
+		}
+
+		code, err := newTokenID()
+		if err != nil {
+			s.respondError(w, r, http.StatusInternalServerError, "Failed to create invite")
+			return
+		}
+		if invite.ID, err = newTokenID(); err != nil {
+			s.respondError(w, r, http.StatusInternalServerError, "Failed to create invite")
+			return
+		}
+		invite.Hash = hashToken(code)
+		if err := s.store.InviteCreate(r.Context(), invite); err != nil {
+			s.respondStoreError(w, r, err, "Failed to create invite")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			db.Invite
+			Code string `json:"code"`
+		}{invite, code})
+	default:
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleInviteRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/admin/invites/")
+	if id == "" {
+		s.respondError(w, r, http.StatusBadRequest, "invite id required")
+		return
+	}
+	revoked, err := s.store.InviteRevoke(r.Context(), id)
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to revoke invite")
+		return
+	}
+	if revoked == 0 {
+		s.respondError(w, r, http.StatusNotFound, "Invite not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
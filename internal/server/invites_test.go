@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+type mintedInvite struct {
+	db.Invite
+	Code string `json:"code"`
+}
+
+func mintInvite(t *testing.T, server *Server, token string, body map[string]interface{}) mintedInvite {
+	t.Helper()
+	w := doRequest(server, "POST", "/api/v1/admin/invites", token, body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected %v, got %v: %v", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var invite mintedInvite
+	if err := json.Unmarshal(w.Body.Bytes(), &invite); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return invite
+}
+
+func register(server *Server, username, email string, code interface{}) int {
+	body := map[string]interface{}{"Username": username, "password": "secret", "email": email}
+	if code != nil {
+		body["registrationCode"] = code
+	}
+	return doRequest(server, "POST", "/api/v1/user", "", body).Code
+}
+
+func TestRegistrationMode(t *testing.T) {
+	tests := []struct {
+		opts    Options
+		want    string
+		wantErr bool
+	}{
+		{Options{}, registrationClosed, false},
+		{Options{RegistrationMode: registrationInvite}, registrationClosed, false},
+		{Options{Registration: true}, registrationOpen, false},
+		{Options{Registration: true, RegistrationMode: "closed"}, registrationClosed, false},
+		{Options{Registration: true, RegistrationMode: "invite"}, registrationInvite, false},
+		{Options{Registration: true, RegistrationMode: "domain", RegistrationDomains: []string{"example.com"}}, registrationDomain, false},
+		{Options{Registration: true, RegistrationMode: "domain"}, "", true},
+		{Options{Registration: true, RegistrationMode: "sometimes"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := registrationMode(tt.opts)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("registrationMode(%+v) = %q, %v; want %q", tt.opts, got, err, tt.want)
+		}
+	}
+}
+
+func TestInviteRegistration(t *testing.T) {
+	open := newTestServer(t, Options{Registration: true, Admins: []string{"invite-admin"}})
+	admin := registerAndLogin(t, open, "invite-admin")
+	member := registerAndLogin(t, open, "invite-member")
+	server := newTestServer(t, Options{Registration: true, RegistrationMode: registrationInvite, Admins: []string{"invite-admin"}})
+
+	if w := doRequest(server, "POST", "/api/v1/admin/invites", member, map[string]interface{}{}); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v for non-admin, got %v", http.StatusForbidden, w.Code)
+	}
+	single := mintInvite(t, server, admin, map[string]interface{}{})
+	multi := mintInvite(t, server, admin, map[string]interface{}{"maxUses": 2, "expires": time.Now().Add(time.Hour).Unix()})
+	if single.MaxUses != 1 || single.Code == "" || multi.MaxUses != 2 {
+		t.Fatalf("unexpected invites %+v %+v", single, multi)
+	}
+
+	if code := register(server, "no-invite", "no-invite@example.com", nil); code != http.StatusForbidden {
+		t.Errorf("expected %v without invite, got %v", http.StatusForbidden, code)
+	}
+	if code := register(server, "bad-invite", "bad-invite@example.com", "wrong"); code != http.StatusForbidden {
+		t.Errorf("expected %v with unknown invite, got %v", http.StatusForbidden, code)
+	}
+	if code := register(server, "invited-1", "invited-1@example.com", single.Code); code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, code)
+	}
+	if code := register(server, "invited-2", "invited-2@example.com", single.Code); code != http.StatusForbidden {
+		t.Errorf("expected single-use invite to be used up, got %v", code)
+	}
+	if code := register(server, "invited-1", "other@example.com", multi.Code); code != http.StatusConflict {
+		t.Errorf("expected %v for taken username, got %v", http.StatusConflict, code)
+	}
+	for _, username := range []string{"invited-3", "invited-4"} {
+		if code := register(server, username, username+"@example.com", multi.Code); code != http.StatusOK {
+			t.Errorf("expected %v, got %v", http.StatusOK, code)
+		}
+	}
+
+	w := doRequest(server, "GET", "/api/v1/admin/invites", admin, nil)
+	var invites []db.Invite
+	json.Unmarshal(w.Body.Bytes(), &invites)
+	if w.Code != http.StatusOK || len(invites) < 2 {
+		t.Fatalf("unexpected invite list %v: %v", w.Code, w.Body.String())
+	}
+	for _, invite := range invites {
+		if invite.ID == multi.ID && invite.Uses != 2 {
+			t.Errorf("expected failed registrations not to use the invite, got %+v", invite)
+		}
+	}
+
+	revocable := mintInvite(t, server, admin, map[string]interface{}{"maxUses": 0})
+	if w := doRequest(server, "DELETE", "/api/v1/admin/invites/"+revocable.ID, admin, nil); w.Code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, w.Code)
+	}
+	if w := doRequest(server, "DELETE", "/api/v1/admin/invites/"+revocable.ID, admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v for revoked invite, got %v", http.StatusNotFound, w.Code)
+	}
+	if code := register(server, "invited-5", "invited-5@example.com", revocable.Code); code != http.StatusForbidden {
+		t.Errorf("expected revoked invite to be rejected, got %v", code)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"maxUses": -1},
+		{"expires": time.Now().Add(-time.Hour).Unix()},
+	} {
+		if w := doRequest(server, "POST", "/api/v1/admin/invites", admin, body); w.Code != http.StatusBadRequest {
+			t.Errorf("expected %v for %v, got %v", http.StatusBadRequest, body, w.Code)
+		}
+	}
+}
+
+// failingCreateStore loses every registration race: UserCreate finds the
+// username taken, or fails with err when it is set.
+type failingCreateStore struct {
+	db.Store
+	err error
+}
+
+func (s failingCreateStore) UserCreate(ctx context.Context, user db.User) (int64, error) {
+	return 0, s.err
+}
+
+func TestFailedRegistrationKeepsInvite(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true, Admins: []string{"release-admin"}})
+	admin := registerAndLogin(t, server, "release-admin")
+	server = newTestServer(t, Options{Registration: true, RegistrationMode: registrationInvite, Admins: []string{"release-admin"}})
+	invite := mintInvite(t, server, admin, map[string]interface{}{})
+
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusConflict},
+		{&db.Error{Op: "create user", Kind: db.ErrUnavailable}, http.StatusServiceUnavailable},
+	} {
+		failing, err := NewServer(failingCreateStore{Store: testStore, err: tt.err}, Options{
+			LogFile: "/dev/null", Registration: true, RegistrationMode: registrationInvite,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if code := register(failing, "release-racer", "release-racer@example.com", invite.Code); code != tt.want {
+			t.Errorf("expected %v, got %v", tt.want, code)
+		}
+	}
+	if code := register(server, "release-racer", "release-racer@example.com", invite.Code); code != http.StatusOK {
+		t.Errorf("expected the invite to survive failed registrations, got %v", code)
+	}
+}
+
+func TestDomainRegistration(t *testing.T) {
+	server := newTestServer(t, Options{
+		Registration:        true,
+		RegistrationMode:    registrationDomain,
+		RegistrationDomains: []string{"corp.example"},
+	})
+	if code := register(server, "domain-ok", "domain-ok@CORP.example", nil); code != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, code)
+	}
+	if code := register(server, "domain-other", "domain-other@example.com", nil); code != http.StatusForbidden {
+		t.Errorf("expected %v, got %v", http.StatusForbidden, code)
+	}
+	if code := register(server, "domain-sub", "domain-sub@evil.corp.example.com", nil); code != http.StatusForbidden {
+		t.Errorf("expected %v, got %v", http.StatusForbidden, code)
+	}
+	if code := register(server, "domain-invalid", "not-an-email", nil); code != http.StatusBadRequest {
+		t.Errorf("expected %v, got %v", http.StatusBadRequest, code)
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// minSecretLength is the smallest HS256 key accepted from configuration;
+// RFC 7518 requires a key at least as large as the hash output.
+const minSecretLength = 32
+
+// signingKeys holds the secret used to sign new tokens and, during a rotation
+// grace window, the secret it replaced which is still accepted on validation.
+type signingKeys struct {
+	mu            sync.RWMutex
+	current       []byte
+	previous      []byte
+	previousUntil time.Time
+}
+
+func (k *signingKeys) set(current, previous []byte, previousUntil time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.current = current
+	k.previous = previous
+	k.previousUntil = previousUntil
+}
+
+// configure sets keys taken from the configuration. The previous key stays
+// valid for grace from when it is first configured: setting the same keys
+// again, as a reload does, keeps that deadline instead of extending it.
+func (k *signingKeys) configure(current, previous []byte, grace time.Duration, now time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !bytes.Equal(current, k.current) || !bytes.Equal(previous, k.previous) {
+		k.previousUntil = now.Add(grace)
+	}
+	k.current = current
+	k.previous = previous
+}
+
+// signing returns the key new tokens are signed with.
+func (k *signingKeys) signing() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// verifying returns every key a token may be signed with at time now.
+func (k *signingKeys) verifying(now time.Time) [][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := [][]byte{k.current}
+	if k.previous != nil && now.Before(k.previousUntil) {
+		keys = append(keys, k.previous)
+	}
+	return keys
+}
+
+// loadKeys sets up the signing keys. A configured JWTSecret takes precedence;
+// otherwise the secret persisted in the database is used, rotating it first
+// when it is older than JWTRotationInterval.
+func (s *Server) loadKeys(ctx context.Context) error {
+	if s.config().JWTSecret != "" {
+		if len(s.config().JWTSecret) < minSecretLength {
+			return fmt.Errorf("jwt secret must be at least %d bytes", minSecretLength)
+		}
+		var previous []byte
+		if s.config().JWTPreviousSecret != "" {
+			if len(s.config().JWTPreviousSecret) < minSecretLength {
+				return fmt.Errorf("previous jwt secret must be at least %d bytes", minSecretLength)
+			}
+			previous = []byte(s.config().JWTPreviousSecret)
+		}
+		s.keys.configure([]byte(s.config().JWTSecret), previous, s.config().JWTRotationGrace, time.Now())
+		return nil
+	}
+
+	secrets, err := s.store.GetSecrets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load jwt secret: %v", err)
+	}
+	if s.config().JWTRotationInterval > 0 && time.Since(secrets[0].Created) > s.config().JWTRotationInterval {
+		if _, err = s.store.RotateSecret(ctx); err != nil {
+			return fmt.Errorf("failed to rotate jwt secret: %v", err)
+		}
+		if secrets, err = s.store.GetSecrets(ctx); err != nil {
+			return fmt.Errorf("failed to load jwt secret: %v", err)
+		}
+	}
+
+	var previous []byte
+	var previousUntil time.Time
+	if len(secrets) > 1 {
+		previous = []byte(secrets[1].Secret)
+		previousUntil = secrets[0].Created.Add(s.config().JWTRotationGrace)
+	}
+	s.keys.set([]byte(secrets[0].Secret), previous, previousUntil)
+	return nil
+}
+
+// rotateKeys periodically reloads database-stored keys so a secret is rotated
+// once it reaches JWTRotationInterval without restarting the server. It
+// returns when ctx is done.
+func (s *Server) rotateKeys(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.loadKeys(ctx); err != nil {
+				s.log.Error("failed to rotate signing keys", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+func issueToken(t *testing.T, server *Server) string {
+	t.Helper()
+	ctx := context.Background()
+	token, err := server.generateToken(ctx, &db.User{ID: 1, Username: "keys", SystemName: "laptop"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return token
+}
+
+func TestSecretPersistsAcrossRestarts(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	first, err := NewServer(store, Options{LogFile: "/dev/null"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token := issueToken(t, first)
+	restarted, err := NewServer(store, Options{LogFile: "/dev/null"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := restarted.validateToken(ctx, token); err != nil {
+		t.Errorf("expected token to survive restart, got %v", err)
+	}
+	other, _ := NewServer(db.NewMemoryStore(), Options{LogFile: "/dev/null"})
+	if _, err := other.validateToken(ctx, token); err == nil {
+		t.Errorf("expected token signed with another secret to be rejected")
+	}
+}
+
+func TestConfiguredSecret(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	if _, err := NewServer(store, Options{LogFile: "/dev/null", JWTSecret: "short"}); err == nil {
+		t.Errorf("expected error for short secret, got nil")
+	}
+	oldSecret := strings.Repeat("a", minSecretLength)
+	newSecret := strings.Repeat("b", minSecretLength)
+	if _, err := NewServer(store, Options{LogFile: "/dev/null", JWTSecret: newSecret, JWTPreviousSecret: "short"}); err == nil {
+		t.Errorf("expected error for short previous secret, got nil")
+	}
+	old, err := NewServer(store, Options{LogFile: "/dev/null", JWTSecret: oldSecret})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token := issueToken(t, old)
+
+	t.Run("previous secret within grace", func(t *testing.T) {
+		server, _ := NewServer(store, Options{
+			LogFile:           "/dev/null",
+			JWTSecret:         newSecret,
+			JWTPreviousSecret: oldSecret,
+			JWTRotationGrace:  time.Hour,
+		})
+		if _, err := server.validateToken(ctx, token); err != nil {
+			t.Errorf("expected token to validate, got %v", err)
+		}
+	})
+	t.Run("previous secret after grace", func(t *testing.T) {
+		server, _ := NewServer(store, Options{
+			LogFile:           "/dev/null",
+			JWTSecret:         newSecret,
+			JWTPreviousSecret: oldSecret,
+		})
+		if _, err := server.validateToken(ctx, token); err == nil {
+			t.Errorf("expected token to be rejected once grace has passed")
+		}
+	})
+}
+
+func TestReloadKeepsRotationGrace(t *testing.T) {
+	ctx := context.Background()
+	opts := Options{
+		LogFile:           "/dev/null",
+		JWTSecret:         strings.Repeat("b", minSecretLength),
+		JWTPreviousSecret: strings.Repeat("a", minSecretLength),
+		JWTRotationGrace:  time.Hour,
+	}
+	server, err := NewServer(db.NewMemoryStore(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deadline := server.keys.previousUntil
+	time.Sleep(time.Millisecond)
+	if err := server.Reload(ctx, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := server.keys.previousUntil; !got.Equal(deadline) {
+		t.Errorf("expected reload to keep the grace deadline %v, got %v", deadline, got)
+	}
+	opts.JWTPreviousSecret = strings.Repeat("c", minSecretLength)
+	if err := server.Reload(ctx, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := server.keys.previousUntil; !got.After(deadline) {
+		t.Errorf("expected a new previous secret to start a new grace, got %v", got)
+	}
+}
+
+func TestStoredSecretRotation(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	server, _ := NewServer(store, Options{LogFile: "/dev/null"})
+	token := issueToken(t, server)
+	if _, err := store.RotateSecret(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	withGrace, _ := NewServer(store, Options{LogFile: "/dev/null", JWTRotationGrace: time.Hour})
+	if _, err := withGrace.validateToken(ctx, token); err != nil {
+		t.Errorf("expected token to validate during grace, got %v", err)
+	}
+	if _, err := withGrace.validateToken(ctx, issueToken(t, withGrace)); err != nil {
+		t.Errorf("expected token signed with the new secret to validate, got %v", err)
+	}
+	withoutGrace, _ := NewServer(store, Options{LogFile: "/dev/null"})
+	if _, err := withoutGrace.validateToken(ctx, token); err == nil {
+		t.Errorf("expected token to be rejected without grace")
+	}
+}
+
+func TestRotationInterval(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	before, _ := store.GetSecret(ctx)
+	if _, err := NewServer(store, Options{LogFile: "/dev/null", JWTRotationInterval: time.Hour}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := store.GetSecret(ctx); current != before {
+		t.Errorf("expected fresh secret to be kept")
+	}
+	if _, err := NewServer(store, Options{LogFile: "/dev/null", JWTRotationInterval: time.Nanosecond}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current, _ := store.GetSecret(ctx); current == before {
+		t.Errorf("expected secret older than the interval to be rotated")
+	}
+}
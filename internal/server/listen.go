@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenAddress turns addr into the network and address to listen on. addr is
+// a URL such as http://0.0.0.0:8080, a host:port, or unix:///path/to.sock for
+// a unix domain socket. secure reports an https URL. URLs without a port
+// listen on the scheme's default port.
+func listenAddress(addr string) (network, address string, secure bool, err error) {
+	if path, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if path == "" {
+			return "", "", false, fmt.Errorf("invalid listen address %q: socket path required", addr)
+		}
+		return "unix", path, false, nil
+	}
+	if !strings.Contains(addr, "://") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", false, fmt.Errorf("invalid listen address %q: %v", addr, err)
+		}
+		return "tcp", addr, false, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid listen address %q: %v", addr, err)
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		secure = true
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return "", "", false, fmt.Errorf("invalid listen address %q: scheme must be http, https or unix", addr)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return "", "", false, fmt.Errorf("invalid listen address %q: paths are not supported", addr)
+	}
+	return "tcp", net.JoinHostPort(u.Hostname(), port), secure, nil
+}
+
+// listeners are the sockets Run serves on. redirect and metrics are nil
+// unless configured.
+type listeners struct {
+	server   net.Listener
+	redirect net.Listener
+	metrics  net.Listener
+}
+
+func (l listeners) close() {
+	for _, ln := range []net.Listener{l.server, l.redirect, l.metrics} {
+		if ln != nil {
+			ln.Close()
+		}
+	}
+}
+
+// listen opens the listener for addr and, with opts.HTTPRedirectAddr, the
+// one redirecting to HTTPS and, with opts.MetricsAddr, the one serving
+// metrics. Under systemd socket activation the inherited sockets are used
+// instead: the ones named "redirect" and "metrics" by FileDescriptorName
+// redirect to HTTPS, when TLS is configured, and serve metrics, with
+// opts.Metrics, and the first other one serves. The server
+// listener speaks TLS when opts name a certificate, which is kept in s.certs
+// to be reloaded on SIGHUP.
+func (s *Server) listen(addr string, opts Options) (l listeners, err error) {
+	_, _, secure, err := listenAddress(addr)
+	if err != nil {
+		return listeners{}, err
+	}
+	if secure && opts.TLSCertFile == "" {
+		return listeners{}, fmt.Errorf("an https address needs a TLS certificate and key")
+	}
+	defer func() {
+		if err != nil {
+			l.close()
+			l = listeners{}
+		}
+	}()
+
+	inherited, names, err := systemdListeners()
+	if err != nil {
+		return listeners{}, err
+	}
+	if inherited != nil {
+		for i, ln := range inherited {
+			switch {
+			case names[i] == "redirect":
+				if opts.TLSCertFile != "" && l.redirect == nil {
+					l.redirect = ln
+				} else {
+					ln.Close()
+				}
+			case names[i] == "metrics":
+				if opts.Metrics && l.metrics == nil {
+					l.metrics = ln
+				} else {
+					ln.Close()
+				}
+			case l.server == nil:
+				l.server = ln
+			default:
+				ln.Close()
+			}
+		}
+		if l.server == nil {
+			return l, errors.New("systemd passed no socket to serve on")
+		}
+	} else {
+		if l.server, err = listenOn(addr, opts.SocketMode); err != nil {
+			return l, err
+		}
+		if opts.HTTPRedirectAddr != "" {
+			if l.redirect, err = listenOn(opts.HTTPRedirectAddr, opts.SocketMode); err != nil {
+				return l, err
+			}
+		}
+		if opts.MetricsAddr != "" {
+			if l.metrics, err = listenOn(opts.MetricsAddr, opts.SocketMode); err != nil {
+				return l, err
+			}
+		}
+	}
+
+	if opts.TLSCertFile != "" {
+		config, certs, err := tlsConfig(opts)
+		if err != nil {
+			return l, err
+		}
+		s.certs = certs
+		l.server = tls.NewListener(l.server, config)
+	}
+	return l, nil
+}
+
+// listenOn listens on addr. Unix sockets get the permissions in mode, or
+// 0660 when it is zero, and replace a stale socket file left behind by a
+// server that did not shut down cleanly.
+func listenOn(addr string, mode os.FileMode) (net.Listener, error) {
+	network, address, _, err := listenAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		mode = 0660
+	}
+	if err := os.Chmod(address, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket deletes the socket file at path unless another process
+// still accepts connections on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%v exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%v is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// listenFDsStart is the first file descriptor passed by systemd.
+var listenFDsStart = 3
+
+// systemdListeners returns the sockets passed by systemd socket activation
+// with their FileDescriptorName, or nil when the process was not socket
+// activated. The LISTEN_* variables are cleared so child processes do not
+// take the sockets for their own.
+func systemdListeners() ([]net.Listener, []string, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS %q from systemd", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for len(names) < n {
+		names = append(names, "")
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("systemd socket %d: %v", fd, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, names[:n], nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		secure  bool
+		wantErr bool
+	}{
+		{"http://0.0.0.0:8080", "tcp", "0.0.0.0:8080", false, false},
+		{"http://localhost:8080/", "tcp", "localhost:8080", false, false},
+		{"https://example.com", "tcp", "example.com:443", true, false},
+		{"http://example.com", "tcp", "example.com:80", false, false},
+		{"http://[::1]:9090", "tcp", "[::1]:9090", false, false},
+		{"0.0.0.0:8080", "tcp", "0.0.0.0:8080", false, false},
+		{":9090", "tcp", ":9090", false, false},
+		{"localhost:8080", "tcp", "localhost:8080", false, false},
+		{"unix:///run/bashhub.sock", "unix", "/run/bashhub.sock", false, false},
+		{"unix://bashhub.sock", "unix", "bashhub.sock", false, false},
+		{"unix://", "", "", false, true},
+		{"localhost", "", "", false, true},
+		{"ftp://example.com:21", "", "", false, true},
+		{"http://example.com:8080/bashhub", "", "", false, true},
+	}
+	for _, tt := range tests {
+		network, address, secure, err := listenAddress(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("listenAddress(%q): unexpected error %v", tt.addr, err)
+			continue
+		}
+		if network != tt.network || address != tt.address || secure != tt.secure {
+			t.Errorf("listenAddress(%q) = %q, %q, %v, want %q, %q, %v", tt.addr, network, address, secure, tt.network, tt.address, tt.secure)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, shorter than some TempDirs.
+	dir, err := os.MkdirTemp("", "bh")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bashhub.sock")
+
+	ln, err := listenOn("unix://"+path, 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected socket mode 0600, got %v (%v)", info.Mode(), err)
+	}
+	server := newTestServer(t, Options{Registration: true})
+	go http.Serve(ln, server)
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://bashhub/ping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %v, got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	if _, err := listenOn("unix://"+path, 0); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected error for a socket in use, got %v", err)
+	}
+	// Leave the socket file behind, as a crashed server would.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenOn("unix://"+path, 0)
+	if err != nil {
+		t.Fatalf("expected a stale socket to be replaced, got %v", err)
+	}
+	ln.Close()
+	if info, err := os.Stat(path); err == nil {
+		t.Errorf("expected the socket to be removed on close, got %v", info.Mode())
+	}
+
+	os.WriteFile(path, []byte("data"), 0600)
+	if _, err := listenOn("unix://"+path, 0); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("expected error for a regular file, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// passSockets passes a socket for each of the colon separated names the way
+// systemd does, as consecutive descriptors owned by no *os.File since
+// systemdListeners takes them over.
+func passSockets(t *testing.T, names string) {
+	t.Helper()
+	n := len(strings.Split(names, ":"))
+	var fds []int
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		f, _ := ln.(*net.TCPListener).File()
+		fd, err := syscall.Dup(int(f.Fd()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		f.Close()
+		ln.Close()
+		fds = append(fds, fd)
+	}
+	// Move them to the first free run of consecutive descriptors.
+	start := fds[0]
+	for free := 0; free < n; start++ {
+		var st syscall.Stat_t
+		if syscall.Fstat(start, &st) == syscall.EBADF {
+			free++
+		} else {
+			free = 0
+		}
+	}
+	start -= n
+	for i, fd := range fds {
+		if err := syscall.Dup2(fd, start+i); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		syscall.Close(fd)
+	}
+	original := listenFDsStart
+	listenFDsStart = start
+	t.Cleanup(func() { listenFDsStart = original })
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", strconv.Itoa(n))
+	t.Setenv("LISTEN_FDNAMES", names)
+}
+
+func TestSystemdListeners(t *testing.T) {
+	if listeners, _, err := systemdListeners(); listeners != nil || err != nil {
+		t.Fatalf("expected no listeners without socket activation, got %v (%v)", listeners, err)
+	}
+
+	passSockets(t, "redirect:bashhub:metrics")
+	server := newTestServer(t, Options{Registration: true})
+	l, err := server.listen("http://0.0.0.0:8080", Options{Metrics: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.close()
+	if l.redirect != nil || l.metrics == nil {
+		t.Fatalf("expected only a metrics listener besides the server, got %+v", l)
+	}
+	if addr := l.server.Addr().String(); addr == l.metrics.Addr().String() {
+		t.Errorf("expected separate listeners, got %v twice", addr)
+	}
+	go http.Serve(l.server, server)
+	resp, err := http.Get("http://" + l.server.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("expected LISTEN_FDS to be cleared")
+	}
+}
+
+func TestSystemdMetricsSocketDisabled(t *testing.T) {
+	passSockets(t, "bashhub:metrics")
+	server := newTestServer(t, Options{Registration: true})
+	l, err := server.listen("http://0.0.0.0:8080", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.close()
+	if l.metrics != nil {
+		t.Errorf("expected the metrics socket to go unused with metrics disabled")
+	}
+}
+
+func TestSystemdRedirectSocket(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	passSockets(t, "bashhub:redirect")
+	l, err := server.listen("http://0.0.0.0:8080", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l.close()
+	if l.redirect != nil {
+		t.Errorf("expected the redirect socket to go unused without TLS")
+	}
+
+	certFile, keyFile := newTestCert(t, "127.0.0.1", nil).write(t, t.TempDir())
+	passSockets(t, "bashhub:redirect")
+	l, err = server.listen("https://0.0.0.0:8443", Options{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer l.close()
+	if l.redirect == nil {
+		t.Errorf("expected the redirect socket to redirect to HTTPS")
+	}
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated log files, so bashhub.log becomes
+// bashhub.log.2026-10-16T09-12-03.000 and, compressed, the same with .gz.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotation says when a log file is rotated and what happens to the rotated
+// files. The zero value never rotates.
+type rotation struct {
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+}
+
+func logRotation(opts Options) rotation {
+	return rotation{
+		maxSize:    opts.LogMaxSize,
+		maxAge:     opts.LogMaxAge,
+		maxBackups: opts.LogMaxBackups,
+		compress:   opts.LogCompress,
+	}
+}
+
+// openLog opens the log destination named by logFile: stderr when empty,
+// nothing for /dev/null and otherwise the file, appended to and rotated as
+// rotate says.
+func openLog(logFile string, rotate rotation) (io.Writer, error) {
+	switch logFile {
+	case "":
+		return os.Stderr, nil
+	case "/dev/null":
+		return io.Discard, nil
+	}
+	return openLogFile(logFile, rotate, time.Now)
+}
+
+// logWriter is the server log. Reload reopens it, so it can follow a file
+// that was moved away by logrotate or point at a new one.
+type logWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// reopen appends to the log file of opts from now on and closes the previous
+// one.
+func (l *logWriter) reopen(opts Options) error {
+	w, err := openLog(opts.LogFile, logRotation(opts))
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	previous := l.w
+	l.w = w
+	l.mu.Unlock()
+	if c, ok := previous.(io.Closer); ok && previous != os.Stderr {
+		c.Close()
+	}
+	return nil
+}
+
+// logFile is a log file that rotates itself. It is not safe for concurrent
+// writes; logWriter serializes them.
+type logFile struct {
+	path    string
+	rotate  rotation
+	now     func() time.Time
+	file    *os.File
+	size    int64
+	started time.Time
+	// cleaning runs compress and prune of rotated files one at a time, in
+	// the background so a large file does not hold up logging.
+	cleaning sync.Mutex
+	cleanups sync.WaitGroup
+}
+
+func openLogFile(path string, rotate rotation, now func() time.Time) (*logFile, error) {
+	f := &logFile{path: path, rotate: rotate, now: now}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	// A file kept from before a restart was started when the last one was
+	// rotated.
+	if backups, _ := f.backups(); f.size > 0 && len(backups) > 0 {
+		f.started = backups[0].time
+	}
+	return f, nil
+}
+
+func (f *logFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.started = file, info.Size(), f.now()
+	return nil
+}
+
+func (f *logFile) Write(p []byte) (int, error) {
+	if f.due(len(p)) {
+		if err := f.rotateNow(); err != nil {
+			// Keep logging to the current file rather than losing lines.
+			fmt.Fprintf(os.Stderr, "failed to rotate log file: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// due reports whether writing n more bytes should go to a new file.
+func (f *logFile) due(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.rotate.maxSize > 0 && f.size+int64(n) > f.rotate.maxSize {
+		return true
+	}
+	return f.rotate.maxAge > 0 && f.now().Sub(f.started) >= f.rotate.maxAge
+}
+
+// rotateNow moves the current file aside and starts a new one.
+func (f *logFile) rotateNow() error {
+	backup := f.path + "." + f.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	previous := f.file
+	if err := f.open(); err != nil {
+		// previous still writes to the renamed file.
+		return err
+	}
+	previous.Close()
+	f.cleanups.Add(1)
+	go func() {
+		defer f.cleanups.Done()
+		f.cleanup()
+	}()
+	return nil
+}
+
+// cleanup compresses rotated files, when configured, and removes those past
+// the retention count.
+func (f *logFile) cleanup() {
+	f.cleaning.Lock()
+	defer f.cleaning.Unlock()
+	backups, err := f.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list rotated log files: %v\n", err)
+		return
+	}
+	for i, b := range backups {
+		if f.rotate.maxBackups > 0 && i >= f.rotate.maxBackups {
+			if err := os.Remove(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to remove rotated log file: %v\n", err)
+			}
+		} else if f.rotate.compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := compressFile(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to compress rotated log file: %v\n", err)
+			}
+		}
+	}
+}
+
+type logBackup struct {
+	path string
+	time time.Time
+}
+
+// backups lists the files rotated from f, newest first. Files rotated by
+// other tools, such as logrotate's bashhub.log.1, are left alone.
+func (f *logFile) backups() ([]logBackup, error) {
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(f.path) + "."
+	var backups []logBackup
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ".gz"))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, logBackup{filepath.Join(filepath.Dir(f.path), e.Name()), t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].time.After(backups[j].time) })
+	return backups, nil
+}
+
+// Close closes the file once rotated files are cleaned up.
+func (f *logFile) Close() error {
+	f.cleanups.Wait()
+	return f.file.Close()
+}
+
+// compressFile replaces path with a gzipped path.gz.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	_, err = io.Copy(gz, in)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
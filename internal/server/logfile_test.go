@@ -0,0 +1,131 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClock is a clock that moves only when told to.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time { return c.t }
+
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// readLog returns the contents of a log file, decompressing .gz files.
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r = gz
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(b)
+}
+
+func TestLogFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	os.WriteFile(path, []byte("before restart\n"), 0644)
+	w, err := openLog(path, rotation{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.Write([]byte("after restart\n"))
+	w.(io.Closer).Close()
+	if got := readLog(t, path); got != "before restart\nafter restart\n" {
+		t.Errorf("expected the log to be appended to, got %q", got)
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+	// A file rotated by logrotate is not counted or touched.
+	os.WriteFile(path+".1", []byte("logrotate\n"), 0644)
+	clock := &testClock{time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}
+	f, err := openLogFile(path, rotation{maxSize: 10, maxBackups: 2, compress: true}, clock.now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		clock.advance(time.Second)
+		f.Write([]byte(line))
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readLog(t, path); got != "fourth\n" {
+		t.Errorf("expected the current file to hold the last line, got %q", got)
+	}
+	backups, err := f.backups()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 rotated files to be kept, got %v", backups)
+	}
+	for i, want := range []string{"third\n", "second\n"} {
+		if !strings.HasSuffix(backups[i].path, ".gz") {
+			t.Errorf("expected %v to be compressed", backups[i].path)
+		}
+		if got := readLog(t, backups[i].path); got != want {
+			t.Errorf("expected %v to hold %q, got %q", backups[i].path, want, got)
+		}
+	}
+	if want := path + ".2026-10-16T09-00-04.000.gz"; backups[0].path != want {
+		t.Errorf("expected the newest rotated file to be %v, got %v", want, backups[0].path)
+	}
+	if got := readLog(t, path+".1"); got != "logrotate\n" {
+		t.Errorf("expected the logrotate file to be left alone, got %q", got)
+	}
+}
+
+func TestLogFileRotationByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	clock := &testClock{time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)}
+	rotate := rotation{maxAge: 24 * time.Hour}
+	f, err := openLogFile(path, rotate, clock.now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Write([]byte("monday\n"))
+	clock.advance(23 * time.Hour)
+	f.Write([]byte("still monday\n"))
+	clock.advance(time.Hour)
+	f.Write([]byte("tuesday\n"))
+	f.Close()
+	if backups, _ := f.backups(); len(backups) != 1 || readLog(t, backups[0].path) != "monday\nstill monday\n" {
+		t.Errorf("expected one day in the rotated file, got %v", backups)
+	}
+
+	// After a restart the file's age counts from the last rotation.
+	clock.advance(23 * time.Hour)
+	f, err = openLogFile(path, rotate, clock.now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clock.advance(time.Hour)
+	f.Write([]byte("wednesday\n"))
+	f.Close()
+	if got := readLog(t, path); got != "wednesday\n" {
+		t.Errorf("expected the restarted file to rotate on time, got %q", got)
+	}
+}
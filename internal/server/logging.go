@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the id of a request, taken from the client or a
+// proxy in front of the server when present, and echoed in every response.
+const requestIDHeader = "X-Request-ID"
+
+// newLogger builds the server's structured logger writing to w in format,
+// text (default) or json, at the level held by level.
+func newLogger(w io.Writer, format string, level *slog.LevelVar) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+}
+
+// parseLogLevel parses debug, info (default), warn or error.
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+}
+
+// requestInfo is what the access log records about a request beyond the
+// request itself. Handlers fill in user once it is authenticated.
+type requestInfo struct {
+	id   string
+	user string
+}
+
+// requestID returns the id sent by the client when it is usable in logs,
+// otherwise a new random one.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		return newRequestID()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return newRequestID()
+		}
+	}
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestInfoFrom returns the requestInfo ServeHTTP attached to ctx, or an
+// empty one for requests that did not go through it.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value("request").(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// setRequestUser records the authenticated user of r for the access log.
+func setRequestUser(r *http.Request, username string) {
+	requestInfoFrom(r.Context()).user = username
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// code returns the status sent, which is 200 when the handler wrote nothing.
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequest writes the access log entry of a served request. Server errors
+// are logged at error level, everything else at info.
+func (s *Server) logRequest(r *http.Request, info *requestInfo, w *statusWriter, duration time.Duration) {
+	status := w.code()
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	s.log.LogAttrs(r.Context(), level, "request",
+		slog.String("request_id", info.id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Int64("bytes", w.bytes),
+		slog.Duration("duration", duration),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("user", info.user),
+		slog.String("user_agent", r.UserAgent()),
+	)
+}
+
+// requestLog returns the logger for messages about r, tagged with its id.
+func (s *Server) requestLog(r *http.Request) *slog.Logger {
+	return s.log.With("request_id", requestInfoFrom(r.Context()).id)
+}
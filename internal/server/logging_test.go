@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// logEntries returns the JSON log lines written to logFile.
+func logEntries(t *testing.T, logFile string) []map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var entries []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected JSON log lines, got %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	server := newTestServer(t, Options{Registration: true, LogFile: logFile, LogFormat: "json"})
+	token := registerAndLogin(t, server, "logged")
+
+	req := httptest.NewRequest("GET", "/api/v1/client-view/status", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "bashhub-client/2.0")
+	req.Header.Set(requestIDHeader, "from-proxy-123")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if got := w.Header().Get(requestIDHeader); got != "from-proxy-123" {
+		t.Errorf("expected the request id to be propagated, got %q", got)
+	}
+
+	entries := logEntries(t, logFile)
+	entry := entries[len(entries)-1]
+	want := map[string]any{
+		"level":      "INFO",
+		"msg":        "request",
+		"request_id": "from-proxy-123",
+		"method":     "GET",
+		"path":       "/api/v1/client-view/status",
+		"status":     float64(http.StatusOK),
+		"bytes":      float64(w.Body.Len()),
+		"user":       "logged",
+		"user_agent": "bashhub-client/2.0",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("expected %s %v, got %v in %v", key, value, entry[key], entry)
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Errorf("expected a duration in %v", entry)
+	}
+
+	w = doRequest(server, "GET", "/api/v1/command/search", "bad-token", nil)
+	var body map[string]any
+	json.Unmarshal(w.Body.Bytes(), &body)
+	id := w.Header().Get(requestIDHeader)
+	if len(id) != 32 || body["requestId"] != id {
+		t.Errorf("expected a generated request id in the header and error body, got %q and %v", id, body["requestId"])
+	}
+	entries = logEntries(t, logFile)
+	if entry := entries[len(entries)-1]; entry["status"] != float64(http.StatusUnauthorized) || entry["user"] != "" {
+		t.Errorf("expected an anonymous 401 entry, got %v", entry)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := map[string]bool{
+		"abc-123":                 true,
+		"":                        false,
+		"has space":               false,
+		"new\nline":               false,
+		strings.Repeat("x", 129):  false,
+		"7f1c2e9a-4b5d-11ee-be56": true,
+	}
+	for header, kept := range tests {
+		r := httptest.NewRequest("GET", "/ping", nil)
+		r.Header.Set(requestIDHeader, header)
+		if got := requestID(r); (got == header) != kept || got == "" {
+			t.Errorf("requestID(%q) = %q, expected it kept: %v", header, got, kept)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "warn.log")
+	server := newTestServer(t, Options{Registration: true, LogFile: logFile, LogFormat: "json", LogLevel: "warn"})
+	doRequest(server, "GET", "/ping", "", nil)
+	if entries := logEntries(t, logFile); len(entries) != 0 {
+		t.Errorf("expected requests to be hidden at warn level, got %v", entries)
+	}
+
+	if err := server.Reload(context.Background(), Options{Registration: true, LogFile: logFile, LogLevel: "debug"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	doRequest(server, "GET", "/ping", "", nil)
+	if entries := logEntries(t, logFile); len(entries) != 1 {
+		t.Errorf("expected the reloaded level to log requests, got %v", entries)
+	}
+
+	for _, opts := range []Options{{LogFormat: "xml"}, {LogLevel: "loud"}} {
+		opts.LogFile = "/dev/null"
+		if _, err := NewServer(testStore, opts); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
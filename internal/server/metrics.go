@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of latency histograms.
+var latencyBuckets = [...]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// activeWindows are the periods active user gauges count requests over.
+var activeWindows = []struct {
+	label  string
+	period time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+type histogram struct {
+	// counts holds the observations of each bucket and, last, those above
+	// every bucket.
+	counts [len(latencyBuckets) + 1]uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets[:], v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+type routeKey struct {
+	method string
+	route  string
+	status int
+}
+
+// metrics collects what /metrics exposes besides the database pool, which is
+// read from the store when scraped.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[routeKey]*histogram
+	search   histogram
+	ingested uint64
+	imported uint64
+	// active holds when each user last made an authenticated request.
+	active map[string]time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[routeKey]*histogram), active: make(map[string]time.Time)}
+}
+
+// observeRequest records a served request. route is the mux pattern that
+// matched it, so paths holding ids do not each get their own series.
+func (m *metrics) observeRequest(r *http.Request, route string, info *requestInfo, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	key := routeKey{method: metricMethod(r.Method), route: route, status: status}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.requests[key]
+	if h == nil {
+		h = &histogram{}
+		m.requests[key] = h
+	}
+	h.observe(d)
+	if info.user != "" {
+		m.active[info.user] = time.Now()
+	}
+}
+
+// metricMethod keeps methods sent by clients from adding unbounded series.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *metrics) observeSearch(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.search.observe(d)
+}
+
+func (m *metrics) commandIngested() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ingested++
+}
+
+func (m *metrics) commandImported() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.imported++
+}
+
+// handleMetrics serves the metrics in the Prometheus text exposition format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var pools map[string]sql.DBStats
+	if pooled, ok := s.store.(interface{ PoolStats() map[string]sql.DBStats }); ok {
+		pools = pooled.PoolStats()
+	}
+	s.metrics.write(w, pools, time.Now())
+}
+
+// metricsHandler serves /metrics alone, for the metrics listener.
+func (s *Server) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+func (m *metrics) write(out io.Writer, pools map[string]sql.DBStats, now time.Time) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	header(w, "bashhub_http_requests_total", "counter", "HTTP requests served, by method, route and status.")
+	for _, key := range keys {
+		sample(w, "bashhub_http_requests_total", routeLabels(key), float64(m.requests[key].count))
+	}
+	header(w, "bashhub_http_request_duration_seconds", "histogram", "Time taken to serve HTTP requests, by method, route and status.")
+	for _, key := range keys {
+		writeHistogram(w, "bashhub_http_request_duration_seconds", routeLabels(key), m.requests[key])
+	}
+
+	header(w, "bashhub_commands_ingested_total", "counter", "Commands recorded by clients.")
+	sample(w, "bashhub_commands_ingested_total", nil, float64(m.ingested))
+	header(w, "bashhub_commands_imported_total", "counter", "Commands imported from history.")
+	sample(w, "bashhub_commands_imported_total", nil, float64(m.imported))
+	header(w, "bashhub_search_duration_seconds", "histogram", "Time taken by command searches in the database.")
+	writeHistogram(w, "bashhub_search_duration_seconds", nil, &m.search)
+
+	longest := activeWindows[len(activeWindows)-1].period
+	for user, seen := range m.active {
+		if now.Sub(seen) > longest {
+			delete(m.active, user)
+		}
+	}
+	header(w, "bashhub_active_users", "gauge", "Users who made an authenticated request within the window, since the server started.")
+	for _, window := range activeWindows {
+		n := 0
+		for _, seen := range m.active {
+			if now.Sub(seen) <= window.period {
+				n++
+			}
+		}
+		sample(w, "bashhub_active_users", []string{"window", window.label}, float64(n))
+	}
+
+	if len(pools) == 0 {
+		return
+	}
+	names := make([]string, 0, len(pools))
+	for name := range pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	header(w, "bashhub_db_connections", "gauge", "Open database connections, by state.")
+	for _, name := range names {
+		sample(w, "bashhub_db_connections", []string{"db", name, "state", "in_use"}, float64(pools[name].InUse))
+		sample(w, "bashhub_db_connections", []string{"db", name, "state", "idle"}, float64(pools[name].Idle))
+	}
+	header(w, "bashhub_db_max_open_connections", "gauge", "Limit on open database connections.")
+	for _, name := range names {
+		sample(w, "bashhub_db_max_open_connections", []string{"db", name}, float64(pools[name].MaxOpenConnections))
+	}
+	header(w, "bashhub_db_wait_count_total", "counter", "Connections waited for because the pool was exhausted.")
+	for _, name := range names {
+		sample(w, "bashhub_db_wait_count_total", []string{"db", name}, float64(pools[name].WaitCount))
+	}
+	header(w, "bashhub_db_wait_duration_seconds_total", "counter", "Time spent waiting for a connection.")
+	for _, name := range names {
+		sample(w, "bashhub_db_wait_duration_seconds_total", []string{"db", name}, pools[name].WaitDuration.Seconds())
+	}
+	header(w, "bashhub_db_closed_connections_total", "counter", "Connections closed by the pool limits, by reason.")
+	for _, name := range names {
+		sample(w, "bashhub_db_closed_connections_total", []string{"db", name, "reason", "max_idle"}, float64(pools[name].MaxIdleClosed))
+		sample(w, "bashhub_db_closed_connections_total", []string{"db", name, "reason", "max_idle_time"}, float64(pools[name].MaxIdleTimeClosed))
+		sample(w, "bashhub_db_closed_connections_total", []string{"db", name, "reason", "max_lifetime"}, float64(pools[name].MaxLifetimeClosed))
+	}
+}
+
+func routeLabels(key routeKey) []string {
+	return []string{"method", key.method, "route", key.route, "status", strconv.Itoa(key.status)}
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample of name. labels alternates label names and
+// values.
+func sample(w io.Writer, name string, labels []string, value float64) {
+	io.WriteString(w, name)
+	if len(labels) > 0 {
+		io.WriteString(w, "{")
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		io.WriteString(w, "}")
+	}
+	fmt.Fprintf(w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// labelEscaper escapes label values as the exposition format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeHistogram(w io.Writer, name string, labels []string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += h.counts[i]
+		sample(w, name+"_bucket", append(labels[:len(labels):len(labels)], "le", strconv.FormatFloat(bound, 'g', -1, 64)), float64(cumulative))
+	}
+	sample(w, name+"_bucket", append(labels[:len(labels):len(labels)], "le", "+Inf"), float64(h.count))
+	sample(w, name+"_sum", labels, h.sum)
+	sample(w, name+"_count", labels, float64(h.count))
+}
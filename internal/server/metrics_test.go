@@ -0,0 +1,147 @@
+package server
+
+import (
+	"database/sql"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// scrape returns the metrics server exposes at /metrics.
+func scrape(t *testing.T, server *Server) string {
+	t.Helper()
+	w := doRequest(server, "GET", "/metrics", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("expected the text exposition format, got %q", got)
+	}
+	return w.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true, Metrics: true})
+	token := registerAndLogin(t, server, "measured")
+	doRequest(server, "POST", "/api/v1/command", token, map[string]interface{}{"command": "make", "path": "/src", "uuid": "metrics-1", "created": 1000})
+	doRequest(server, "POST", "/api/v1/command", token, map[string]interface{}{"command": "make", "path": "/src", "uuid": "metrics-1", "created": 1000})
+	doRequest(server, "POST", "/api/v1/import", token, map[string]interface{}{"command": "ls", "path": "/", "uuid": "metrics-2", "created": 2000})
+	doRequest(server, "GET", "/api/v1/command/search?query=make", token, nil)
+	doRequest(server, "GET", "/api/v1/command/metrics-1", token, nil)
+	doRequest(server, "GET", "/api/v1/command/metrics-2", token, nil)
+	doRequest(server, "GET", "/nowhere", "", nil)
+
+	body := scrape(t, server)
+	for _, line := range []string{
+		`bashhub_http_requests_total{method="POST",route="/api/v1/command",status="201"} 1`,
+		`bashhub_http_requests_total{method="POST",route="/api/v1/command",status="409"} 1`,
+		`bashhub_http_requests_total{method="GET",route="/api/v1/command/",status="200"} 3`,
+		`bashhub_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`bashhub_http_request_duration_seconds_bucket{method="POST",route="/api/v1/import",status="200",le="+Inf"} 1`,
+		`bashhub_http_request_duration_seconds_count{method="POST",route="/api/v1/login",status="200"} 1`,
+		`bashhub_commands_ingested_total 1`,
+		`bashhub_commands_imported_total 1`,
+		`bashhub_search_duration_seconds_count 1`,
+		`bashhub_active_users{window="5m"} 1`,
+		`bashhub_active_users{window="24h"} 1`,
+		"# TYPE bashhub_search_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%v", line, body)
+		}
+	}
+	_, pooled := testStore.(interface{ PoolStats() map[string]sql.DBStats })
+	if got := strings.Contains(body, `bashhub_db_max_open_connections{db="primary"}`); got != pooled {
+		t.Errorf("expected pool metrics only from a store with a pool, got them: %v", got)
+	}
+}
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetrics()
+	now := time.Now()
+	m.active["recent"] = now.Add(-time.Minute)
+	m.active["today"] = now.Add(-2 * time.Hour)
+	m.active["gone"] = now.Add(-25 * time.Hour)
+	m.search.observe(3 * time.Millisecond)
+	m.search.observe(300 * time.Millisecond)
+	m.search.observe(time.Minute)
+	pools := map[string]sql.DBStats{
+		"primary": {MaxOpenConnections: 50, InUse: 3, Idle: 7, WaitCount: 2, WaitDuration: 1500 * time.Millisecond},
+		"replica": {MaxOpenConnections: 10, MaxLifetimeClosed: 4},
+	}
+	var b strings.Builder
+	m.write(&b, pools, now)
+	body := b.String()
+	for _, line := range []string{
+		`bashhub_active_users{window="5m"} 1`,
+		`bashhub_active_users{window="1h"} 1`,
+		`bashhub_active_users{window="24h"} 2`,
+		`bashhub_search_duration_seconds_bucket{le="0.005"} 1`,
+		`bashhub_search_duration_seconds_bucket{le="0.25"} 1`,
+		`bashhub_search_duration_seconds_bucket{le="0.5"} 2`,
+		`bashhub_search_duration_seconds_bucket{le="10"} 2`,
+		`bashhub_search_duration_seconds_bucket{le="+Inf"} 3`,
+		`bashhub_search_duration_seconds_sum 60.303`,
+		`bashhub_db_connections{db="primary",state="in_use"} 3`,
+		`bashhub_db_connections{db="primary",state="idle"} 7`,
+		`bashhub_db_max_open_connections{db="replica"} 10`,
+		`bashhub_db_wait_count_total{db="primary"} 2`,
+		`bashhub_db_wait_duration_seconds_total{db="primary"} 1.5`,
+		`bashhub_db_closed_connections_total{db="replica",reason="max_lifetime"} 4`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%v", line, body)
+		}
+	}
+	if _, ok := m.active["gone"]; ok {
+		t.Errorf("expected users idle past the longest window to be forgotten")
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	opts := Options{Registration: true, Metrics: true, MetricsAddr: "127.0.0.1:0"}
+	server := newTestServer(t, opts)
+	if w := doRequest(server, "GET", "/metrics", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected metrics off the main listener, got %v", w.Code)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metricsLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- server.serve(listeners{server: ln, metrics: metricsLn}, opts, signals) }()
+	defer func() {
+		signals <- syscall.SIGTERM
+		<-done
+	}()
+
+	http.Get("http://" + ln.Addr().String() + "/ping")
+	resp, err := http.Get("http://" + metricsLn.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := `bashhub_http_requests_total{method="GET",route="/ping",status="200"} 1`; !strings.Contains(string(b), want) {
+		t.Errorf("expected %q from the metrics listener, got:\n%s", want, b)
+	}
+	resp, err = http.Get("http://" + metricsLn.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the metrics listener to serve only /metrics, got %v", resp.StatusCode)
+	}
+}
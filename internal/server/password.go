@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// passwordResetLifetime is how long an issued reset token can be used.
+const passwordResetLifetime = time.Hour
+
+// handlePasswordChange sets a new password for the caller, who must prove the
+// current one. Every other session of the user is revoked.
+func (s *Server) handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if _, ok := r.Context().Value("apiKey").(string); ok {
+		s.respondError(w, r, http.StatusForbidden, "Passwords cannot be changed with an API key")
+		return
+	}
+	user := r.Context().Value("user").(*db.User)
+	current, _ := r.Context().Value("session").(string)
+
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.NewPassword == "" {
+		s.respondError(w, r, http.StatusBadRequest, "newPassword required")
+		return
+	}
+	if err := s.store.UserExists(r.Context(), db.User{Username: user.Username, Password: body.CurrentPassword}); errors.Is(err, db.ErrNotFound) {
+		s.respondError(w, r, http.StatusUnauthorized, "Invalid credentials")
+		return
+	} else if err != nil {
+		s.respondStoreError(w, r, err, "Failed to change password")
+		return
+	}
+
+	if _, err := s.store.UserSetPassword(r.Context(), user.ID, body.NewPassword); err != nil {
+		s.respondStoreError(w, r, err, "Failed to change password")
+		return
+	}
+	revoked, err := s.store.SessionRevokeOthers(r.Context(), user.ID, current)
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to revoke sessions")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+}
+
+// handlePasswordResetIssue lets an administrator issue a one-time reset token
+// for a user. The token is handed to the configured reset delivery, never to
+// the administrator.
+func (s *Server) handlePasswordResetIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Username == "" {
+		s.respondError(w, r, http.StatusBadRequest, "username required")
+		return
+	}
+	id, err := s.store.UserGetID(r.Context(), db.User{Username: body.Username})
+	if errors.Is(err, db.ErrNotFound) {
+		s.respondError(w, r, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		s.respondStoreError(w, r, err, "Failed to issue password reset")
+		return
+	}
+	user, err := s.store.UserGetByID(r.Context(), id)
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to issue password reset")
+		return
+	}
+
+	expires, err := s.issuePasswordReset(r.Context(), user)
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to issue password reset")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int64{"expires": expires.Unix()})
+}
+
+// issuePasswordReset records a reset token for user and delivers it.
+func (s *Server) issuePasswordReset(ctx context.Context, user db.User) (time.Time, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	expires := now.Add(passwordResetLifetime)
+	err = s.store.PasswordResetCreate(ctx, db.PasswordReset{
+		Hash:    hashToken(token),
+		UserID:  user.ID,
+		Created: now.Unix(),
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expires, s.config().resets.send(user, token, expires)
+}
+
+// handlePasswordReset sets a new password using a reset token and logs the
+// user out everywhere.
+func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Token == "" || body.NewPassword == "" {
+		s.respondError(w, r, http.StatusBadRequest, "token and newPassword required")
+		return
+	}
+
+	reset, err := s.store.PasswordResetUse(r.Context(), hashToken(body.Token))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		s.respondStoreError(w, r, err, "Failed to reset password")
+		return
+	}
+	if err != nil || reset.Used || time.Now().Unix() > reset.Expires {
+		s.respondError(w, r, http.StatusUnauthorized, "Invalid or expired reset token")
+		return
+	}
+
+	if _, err := s.store.UserSetPassword(r.Context(), reset.UserID, body.NewPassword); err != nil {
+		s.respondStoreError(w, r, err, "Failed to reset password")
+		return
+	}
+	if _, err := s.store.SessionRevokeAll(r.Context(), reset.UserID); err != nil {
+		s.respondStoreError(w, r, err, "Failed to revoke sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
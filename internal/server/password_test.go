@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPasswordChange(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	token := registerAndLogin(t, server, "changer")
+	other := login(t, server, "changer").AccessToken
+
+	w := doRequest(server, "PUT", "/api/v1/user/password", token, map[string]string{
+		"currentPassword": "wrong", "newPassword": "changed",
+	})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v for wrong current password, got %v", http.StatusUnauthorized, w.Code)
+	}
+	w = doRequest(server, "PUT", "/api/v1/user/password", token, map[string]string{"currentPassword": "secret"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v for missing new password, got %v", http.StatusBadRequest, w.Code)
+	}
+	key := createAPIKey(t, server, token, map[string]interface{}{"name": "ci", "scope": "read-write"})
+	w = doRequest(server, "PUT", "/api/v1/user/password", key.Key, map[string]string{
+		"currentPassword": "secret", "newPassword": "changed",
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %v with an API key, got %v", http.StatusForbidden, w.Code)
+	}
+
+	w = doRequest(server, "PUT", "/api/v1/user/password", token, map[string]string{
+		"currentPassword": "secret", "newPassword": "changed",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", token, nil); w.Code != http.StatusOK {
+		t.Errorf("expected the current session to stay valid, got %v", w.Code)
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", other, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected other sessions to be revoked, got %v", w.Code)
+	}
+	w = doRequest(server, "POST", "/api/v1/login", "", map[string]string{"Username": "changer", "password": "secret"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected old password to be rejected, got %v", w.Code)
+	}
+	w = doRequest(server, "POST", "/api/v1/login", "", map[string]string{"Username": "changer", "password": "changed"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected new password to log in, got %v", w.Code)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	sink := filepath.Join(t.TempDir(), "resets.jsonl")
+	server := newTestServer(t, Options{
+		Registration:  true,
+		ResetDelivery: "file://" + sink,
+	})
+	admin := registerAndLogin(t, server, "resetadmin")
+	promote(t, "resetadmin")
+	forgetful := registerAndLogin(t, server, "forgetful")
+
+	if w := doRequest(server, "POST", "/api/v1/admin/password-resets", forgetful, map[string]string{"username": "forgetful"}); w.Code != http.StatusForbidden {
+		t.Errorf("expected %v for non-admin, got %v", http.StatusForbidden, w.Code)
+	}
+	if w := doRequest(server, "POST", "/api/v1/admin/password-resets", admin, map[string]string{"username": "nobody"}); w.Code != http.StatusNotFound {
+		t.Errorf("expected %v for unknown user, got %v", http.StatusNotFound, w.Code)
+	}
+	w := doRequest(server, "POST", "/api/v1/admin/password-resets", admin, map[string]string{"username": "forgetful"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected %v, got %v: %v", http.StatusAccepted, w.Code, w.Body.String())
+	}
+
+	data, err := os.ReadFile(sink)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var delivered struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Token    string `json:"token"`
+	}
+	if err := json.Unmarshal(data, &delivered); err != nil {
+		t.Fatalf("failed to unmarshal delivered reset: %v", err)
+	}
+	if delivered.Username != "forgetful" || delivered.Email != "forgetful@example.com" || delivered.Token == "" {
+		t.Fatalf("unexpected delivered reset %+v", delivered)
+	}
+	if strings.Contains(w.Body.String(), delivered.Token) {
+		t.Errorf("expected the token not to be returned to the administrator")
+	}
+
+	body := map[string]string{"token": delivered.Token, "newPassword": "remembered"}
+	if w := doRequest(server, "POST", "/api/v1/user/password/reset", "", map[string]string{"token": delivered.Token}); w.Code != http.StatusBadRequest {
+		t.Errorf("expected %v without a new password, got %v", http.StatusBadRequest, w.Code)
+	}
+	if w := doRequest(server, "POST", "/api/v1/user/password/reset", "", body); w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := doRequest(server, "POST", "/api/v1/user/password/reset", "", body); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected reused token to be rejected, got %v", w.Code)
+	}
+	if w := doRequest(server, "POST", "/api/v1/user/password/reset", "", map[string]string{"token": "unknown", "newPassword": "x"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unknown token to be rejected, got %v", w.Code)
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", forgetful, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected existing sessions to be revoked, got %v", w.Code)
+	}
+	w = doRequest(server, "POST", "/api/v1/login", "", map[string]string{"Username": "forgetful", "password": "remembered"})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected new password to log in, got %v", w.Code)
+	}
+}
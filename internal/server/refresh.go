@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// tokenResponse is returned by login and refresh. accessToken keeps the name
+// bashhub-client reads; the other fields are for clients that refresh.
+type tokenResponse struct {
+	AccessToken      string `json:"accessToken"`
+	TokenType        string `json:"tokenType"`
+	ExpiresIn        int64  `json:"expiresIn"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresIn int64  `json:"refreshExpiresIn"`
+}
+
+// newOpaqueToken returns a random bearer secret for refresh, reset and API
+// key tokens.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the form opaque tokens are stored in, so a leaked
+// database does not leak usable credentials.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession starts a session for user and issues its first access token,
+// valid for accessLifetime, and refresh token.
+func (s *Server) startSession(ctx context.Context, user *db.User, accessLifetime time.Duration) (tokenResponse, error) {
+	now := time.Now()
+	expires := now.Add(s.config().RefreshTokenLifetime)
+	if accessLifetime > s.config().RefreshTokenLifetime {
+		expires = now.Add(accessLifetime)
+	}
+	sessionID, err := s.createSession(ctx, user, now, expires)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return s.issueTokens(ctx, user, sessionID, now, accessLifetime)
+}
+
+// issueTokens signs an access token for the session and records a new refresh
+// token for it.
+func (s *Server) issueTokens(ctx context.Context, user *db.User, sessionID string, now time.Time, accessLifetime time.Duration) (tokenResponse, error) {
+	access, err := s.accessToken(user, sessionID, now, accessLifetime)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refresh, err := newOpaqueToken()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	err = s.store.RefreshTokenCreate(ctx, db.RefreshToken{
+		Hash:      hashToken(refresh),
+		SessionID: sessionID,
+		Created:   now.Unix(),
+		Expires:   now.Add(s.config().RefreshTokenLifetime).Unix(),
+	})
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("failed to record refresh token: %w", err)
+	}
+	return tokenResponse{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(accessLifetime / time.Second),
+		RefreshToken:     refresh,
+		RefreshExpiresIn: int64(s.config().RefreshTokenLifetime / time.Second),
+	}, nil
+}
+
+// handleTokenRefresh exchanges a refresh token for a new access token and a
+// new refresh token. Each refresh token works once; presenting a used one
+// means it leaked, so the whole session is revoked.
+func (s *Server) handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.RefreshToken == "" {
+		s.respondError(w, r, http.StatusBadRequest, "refreshToken required")
+		return
+	}
+
+	token, err := s.store.RefreshTokenUse(r.Context(), hashToken(body.RefreshToken))
+	if errors.Is(err, db.ErrNotFound) {
+		s.respondError(w, r, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to refresh token")
+		return
+	}
+
+	session, err := s.store.SessionGet(r.Context(), token.SessionID)
+	if isStoreFailure(err) {
+		s.respondStoreError(w, r, err, "Failed to refresh token")
+		return
+	} else if err != nil {
+		s.respondError(w, r, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	if token.Used {
+		s.store.SessionRevoke(r.Context(), session.UserID, session.ID)
+		s.respondError(w, r, http.StatusUnauthorized, "Refresh token reuse detected, session revoked")
+		return
+	}
+	now := time.Now()
+	if session.Revoked || now.Unix() > token.Expires {
+		s.respondError(w, r, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	user, err := s.store.UserGetByID(r.Context(), session.UserID)
+	if isStoreFailure(err) {
+		s.respondStoreError(w, r, err, "Failed to refresh token")
+		return
+	} else if err != nil || user.Disabled {
+		s.respondError(w, r, http.StatusUnauthorized, "Invalid refresh token")
+		return
+	}
+	user.SystemName = session.SystemName
+
+	tokens, err := s.issueTokens(r.Context(), &user, session.ID, now, s.config().AccessTokenLifetime)
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to refresh token")
+		return
+	}
+	if err := s.store.SessionExtend(r.Context(), session.ID, now.Add(s.config().RefreshTokenLifetime).Unix()); err != nil {
+		s.respondStoreError(w, r, err, "Failed to refresh token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
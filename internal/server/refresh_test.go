@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func login(t *testing.T, server *Server, username string) tokenResponse {
+	t.Helper()
+	w := doRequest(server, "POST", "/api/v1/login", "", map[string]interface{}{
+		"Username": username, "password": "secret",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+	}
+	var tokens tokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return tokens
+}
+
+func refresh(server *Server, refreshToken string) (int, tokenResponse) {
+	w := doRequest(server, "POST", "/api/v1/token/refresh", "", map[string]string{"refreshToken": refreshToken})
+	var tokens tokenResponse
+	json.Unmarshal(w.Body.Bytes(), &tokens)
+	return w.Code, tokens
+}
+
+func TestLoginTokenLifetimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		expires time.Duration
+	}{
+		{"short-lived by default", Options{}, defaultAccessTokenLifetime},
+		{"configured lifetime", Options{AccessTokenLifetime: time.Minute}, time.Minute},
+		{"legacy long-lived mode", Options{LegacyTokenLifetime: tokenLifetime}, tokenLifetime},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Registration = true
+			server := newTestServer(t, tt.opts)
+			username := "lifetime" + itoa(int64(i))
+			registerAndLogin(t, server, username)
+			tokens := login(t, server, username)
+			if tokens.ExpiresIn != int64(tt.expires/time.Second) {
+				t.Errorf("expected expiresIn %d, got %d", int64(tt.expires/time.Second), tokens.ExpiresIn)
+			}
+			if tokens.TokenType != "Bearer" || tokens.RefreshToken == "" {
+				t.Errorf("expected bearer token with refresh token, got %+v", tokens)
+			}
+			c, err := server.parseToken(tokens.AccessToken)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.ExpiresAt-c.IssuedAt != tokens.ExpiresIn {
+				t.Errorf("expected exp-iat %d, got %d", tokens.ExpiresIn, c.ExpiresAt-c.IssuedAt)
+			}
+		})
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true, LegacyTokenLifetime: tokenLifetime})
+	registerAndLogin(t, server, "refresher")
+	first := login(t, server, "refresher")
+
+	code, second := refresh(server, first.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("expected %v, got %v", http.StatusOK, code)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Errorf("expected refresh to rotate both tokens")
+	}
+	if second.ExpiresIn != int64(defaultAccessTokenLifetime/time.Second) {
+		t.Errorf("expected refreshed access token to be short-lived, got %d", second.ExpiresIn)
+	}
+	firstClaims, _ := server.parseToken(first.AccessToken)
+	secondClaims, err := server.parseToken(second.AccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secondClaims.session() != firstClaims.session() || secondClaims.Username != "refresher" {
+		t.Errorf("expected refreshed token in the same session, got %+v", secondClaims)
+	}
+	if w := doRequest(server, "GET", "/api/v1/sessions", second.AccessToken, nil); w.Code != http.StatusOK {
+		t.Errorf("expected refreshed access token to work, got %v", w.Code)
+	}
+
+	t.Run("reuse revokes the chain", func(t *testing.T) {
+		if code, _ := refresh(server, first.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("expected reused refresh token to be rejected, got %v", code)
+		}
+		for _, token := range []string{first.AccessToken, second.AccessToken} {
+			if w := doRequest(server, "GET", "/api/v1/sessions", token, nil); w.Code != http.StatusUnauthorized {
+				t.Errorf("expected access token of revoked chain to be rejected, got %v", w.Code)
+			}
+		}
+		if code, _ := refresh(server, second.RefreshToken); code != http.StatusUnauthorized {
+			t.Errorf("expected latest refresh token of revoked chain to be rejected, got %v", code)
+		}
+	})
+	t.Run("other sessions are untouched", func(t *testing.T) {
+		other := login(t, server, "refresher")
+		if code, _ := refresh(server, other.RefreshToken); code != http.StatusOK {
+			t.Errorf("expected %v, got %v", http.StatusOK, code)
+		}
+	})
+	t.Run("invalid requests", func(t *testing.T) {
+		if code, _ := refresh(server, "unknown"); code != http.StatusUnauthorized {
+			t.Errorf("expected %v for unknown token, got %v", http.StatusUnauthorized, code)
+		}
+		if code, _ := refresh(server, ""); code != http.StatusBadRequest {
+			t.Errorf("expected %v for missing token, got %v", http.StatusBadRequest, code)
+		}
+		if w := doRequest(server, "GET", "/api/v1/token/refresh", "", nil); w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected %v, got %v", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+}
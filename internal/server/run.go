@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+func Run(dbFile string, addr string, opts Options) {
+	store, err := db.Open(dbFile, db.Options{
+		SkipMigrations:  opts.SkipMigrations,
+		QueryTimeout:    opts.QueryTimeout,
+		MaxOpenConns:    opts.DBMaxOpenConns,
+		MaxIdleConns:    opts.DBMaxIdleConns,
+		ConnMaxLifetime: opts.DBConnMaxLifetime,
+		ConnMaxIdleTime: opts.DBConnMaxIdleTime,
+		ConnectTimeout:  opts.DBConnectTimeout,
+		ReadReplica:     opts.DBReadReplica,
+		Logf:            log.Printf,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = run(store, addr, opts)
+	if closeErr := store.Close(); closeErr != nil {
+		log.Printf("Failed to close the database: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Server stopped")
+}
+
+// run serves store on addr until SIGINT or SIGTERM.
+func run(store db.Store, addr string, opts Options) error {
+	server, err := NewServer(store, opts)
+	if err != nil {
+		return err
+	}
+	// Messages written with the log package become structured too.
+	slog.SetDefault(server.log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if opts.JWTSecret == "" && opts.JWTRotationInterval > 0 {
+		go server.rotateKeys(ctx, min(time.Hour, opts.JWTRotationInterval))
+	}
+	l, err := server.listen(addr, opts)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	return server.serve(l, opts, signals)
+}
+
+// serve handles requests on l.server, redirects to HTTPS on l.redirect and
+// serves metrics on l.metrics, when they are not nil, until a listener fails
+// or signals delivers SIGINT or SIGTERM. Then it stops accepting connections
+// and drains in-flight requests for up to opts.ShutdownTimeout. SIGHUP
+// reloads the configuration, the log file and the TLS certificate.
+func (s *Server) serve(l listeners, opts Options, signals <-chan os.Signal) error {
+	servers := []*http.Server{newHTTPServer(s, opts)}
+	serving := []net.Listener{l.server}
+	if l.redirect != nil {
+		servers = append(servers, newHTTPServer(redirectHandler(l.server.Addr().String()), opts))
+		serving = append(serving, l.redirect)
+		s.log.Info("redirecting to HTTPS", "addr", l.redirect.Addr().String())
+	}
+	if l.metrics != nil {
+		servers = append(servers, newHTTPServer(s.metricsHandler(), opts))
+		serving = append(serving, l.metrics)
+		s.log.Info("serving metrics", "addr", l.metrics.Addr().String())
+	}
+	errs := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() { errs <- srv.Serve(serving[i]) }()
+	}
+	s.log.Info("starting server", "addr", l.server.Addr().String())
+
+	for {
+		select {
+		case err := <-errs:
+			shutdown(servers, 0)
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				s.reload(opts)
+				continue
+			}
+			s.log.Info("shutting down, finishing in-flight requests", "signal", sig.String(), "timeout", opts.ShutdownTimeout)
+			if err := shutdown(servers, opts.ShutdownTimeout); err != nil {
+				s.log.Warn("shutdown deadline passed, closed the remaining connections", "error", err)
+			}
+			return nil
+		}
+	}
+}
+
+func newHTTPServer(handler http.Handler, opts Options) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		IdleTimeout:  opts.IdleTimeout,
+	}
+}
+
+// reload handles SIGHUP. Failures are logged and leave the running
+// configuration in place.
+func (s *Server) reload(opts Options) {
+	if opts.Reload != nil {
+		next, err := opts.Reload()
+		if err == nil {
+			err = s.Reload(context.Background(), next)
+		}
+		if err != nil {
+			s.log.Error("keeping the current configuration", "error", err)
+		} else {
+			s.log.Info("reloaded configuration")
+		}
+	} else if err := s.logger.reopen(s.config().Options); err != nil {
+		s.log.Error("failed to reopen log file", "error", err)
+	}
+	if s.certs != nil {
+		if err := s.certs.reload(); err != nil {
+			s.log.Error("keeping the current TLS certificate", "error", err)
+		} else {
+			s.log.Info("reloaded TLS certificate")
+		}
+	}
+}
+
+// shutdown gracefully stops servers, waiting up to timeout for their requests
+// before closing the connections still open.
+func shutdown(servers []*http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// startServe runs serve on a local port, returning its address, the signal
+// channel it listens to and the channel its result is sent on.
+func startServe(t *testing.T, server *Server, opts Options) (string, chan os.Signal, chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- server.serve(listeners{server: ln}, opts, signals) }()
+	return "http://" + ln.Addr().String(), signals, done
+}
+
+func TestServeDrainsOnShutdown(t *testing.T) {
+	server, err := NewServer(db.NewMemoryStore(), Options{Registration: true, LogFile: "/dev/null"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token := registerAndLogin(t, server, "drain")
+	url, signals, done := startServe(t, server, Options{ShutdownTimeout: 5 * time.Second})
+
+	// An import is still uploading when the shutdown starts.
+	body, upload := io.Pipe()
+	req, _ := http.NewRequest("POST", url+"/api/v1/import", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+	upload.Write([]byte(`{"command": "make", "path": "/src", `))
+	time.Sleep(100 * time.Millisecond)
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		t.Fatalf("expected serve to wait for the import, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	upload.Write([]byte(`"created": 1000, "uuid": "drained-import"}`))
+	upload.Close()
+
+	if status := <-responses; status != http.StatusOK {
+		t.Errorf("expected the in-flight import to finish with %v, got %v", http.StatusOK, status)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := http.Get(url + "/ping"); err == nil {
+		t.Errorf("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestServeShutdownDeadline(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	token := registerAndLogin(t, server, "deadline")
+	url, signals, done := startServe(t, server, Options{ShutdownTimeout: 50 * time.Millisecond})
+
+	body, upload := io.Pipe()
+	defer upload.Close()
+	req, _ := http.NewRequest("POST", url+"/api/v1/import", body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	go func() {
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	upload.Write([]byte(`{"command": `))
+	time.Sleep(100 * time.Millisecond)
+
+	signals <- syscall.SIGINT
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the stalled request to be cut off at the deadline")
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "server.log")
+	opts := Options{Registration: true, LogFile: logFile}
+	server := newTestServer(t, opts)
+	reloaded := opts
+	reloaded.Registration = false
+	opts.Reload = func() (Options, error) { return reloaded, nil }
+	url, signals, done := startServe(t, server, opts)
+
+	// Rotate the log away; the reload starts a new file.
+	os.Rename(logFile, logFile+".1")
+	signals <- syscall.SIGHUP
+	deadline := time.Now().Add(5 * time.Second)
+	for server.config().registration != registrationClosed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Post(url+"/api/v1/user", "application/json", strings.NewReader(`{"username": "late", "password": "secret", "email": "late@example.com"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected registration to be closed after reload, got %v", resp.StatusCode)
+	}
+	if b, _ := os.ReadFile(logFile); !strings.Contains(string(b), "/api/v1/user") {
+		t.Errorf("expected requests to be logged to the reopened file, got %q", b)
+	}
+
+	reloaded.RegistrationMode = "friends"
+	reloaded.Registration = true
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGTERM
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if server.config().registration != registrationClosed {
+		t.Errorf("expected an invalid configuration to leave the running one in place")
+	}
+}
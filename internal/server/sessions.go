@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// handleSessions lists the caller's active device logins on GET and logs out
+// everywhere, revoking every session including the current one, on DELETE.
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value("user").(*db.User)
+	current, _ := r.Context().Value("session").(string)
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.store.SessionList(r.Context(), user.ID)
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to list sessions")
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sessions)
+	case http.MethodDelete:
+		revoked, err := s.store.SessionRevokeAll(r.Context(), user.ID)
+		if err != nil {
+			s.respondStoreError(w, r, err, "Failed to revoke sessions")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+	default:
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		s.respondError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	user := r.Context().Value("user").(*db.User)
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/sessions/")
+	if id == "" {
+		s.respondError(w, r, http.StatusBadRequest, "session id required")
+		return
+	}
+
+	revoked, err := s.store.SessionRevoke(r.Context(), user.ID, id)
+	if err != nil {
+		s.respondStoreError(w, r, err, "Failed to revoke session")
+		return
+	}
+	if revoked == 0 {
+		s.respondError(w, r, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
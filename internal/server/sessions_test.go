@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+func listSessions(t *testing.T, server *Server, token string) []db.Session {
+	t.Helper()
+	w := doRequest(server, "GET", "/api/v1/sessions", token, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+	}
+	var sessions []db.Session
+	if err := json.Unmarshal(w.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	return sessions
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	laptop := registerAndLogin(t, server, "sessionowner")
+	desktop := registerAndLogin(t, server, "sessionowner-desktop")
+	w := doRequest(server, "POST", "/api/v1/login", "", map[string]interface{}{
+		"Username": "sessionowner", "password": "secret",
+	})
+	var response tokenResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	phone := response.AccessToken
+
+	sessions := listSessions(t, server, laptop)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %+v", sessions)
+	}
+	var phoneSession string
+	for _, session := range sessions {
+		if session.Current {
+			continue
+		}
+		phoneSession = session.ID
+	}
+	if phoneSession == "" {
+		t.Fatalf("expected exactly one session to be marked current, got %+v", sessions)
+	}
+
+	t.Run("other users cannot revoke", func(t *testing.T) {
+		w := doRequest(server, "DELETE", "/api/v1/sessions/"+phoneSession, desktop, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+	t.Run("revoke one device", func(t *testing.T) {
+		w := doRequest(server, "DELETE", "/api/v1/sessions/"+phoneSession, laptop, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %v, got %v", http.StatusOK, w.Code)
+		}
+		w = doRequest(server, "GET", "/api/v1/command/search", phone, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected revoked token to be rejected, got %v", w.Code)
+		}
+		if sessions := listSessions(t, server, laptop); len(sessions) != 1 || !sessions[0].Current {
+			t.Errorf("expected only the current session, got %+v", sessions)
+		}
+		w = doRequest(server, "DELETE", "/api/v1/sessions/"+phoneSession, laptop, nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected %v for already revoked session, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+	t.Run("log out everywhere", func(t *testing.T) {
+		w := doRequest(server, "DELETE", "/api/v1/sessions", laptop, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected %v, got %v", http.StatusOK, w.Code)
+		}
+		w = doRequest(server, "GET", "/api/v1/sessions", laptop, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected current token to be revoked, got %v", w.Code)
+		}
+		w = doRequest(server, "GET", "/api/v1/sessions", desktop, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("expected other user's session to stay valid, got %v", w.Code)
+		}
+	})
+	t.Run("method not allowed", func(t *testing.T) {
+		w := doRequest(server, "POST", "/api/v1/sessions", desktop, nil)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("expected %v, got %v", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// certReloader serves the certificate in certFile and keyFile, reloading them
+// on request so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload reads the key pair again. On failure the previous certificate keeps
+// being served.
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// tlsConfig builds the TLS configuration Run serves with. Client certificates
+// are verified against opts.TLSClientCA when it is set, and required with
+// opts.TLSRequireClientCert.
+func tlsConfig(opts Options) (*tls.Config, *certReloader, error) {
+	certs, err := newCertReloader(opts.TLSCertFile, opts.TLSKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	if opts.TLSClientCA != "" {
+		pem, err := os.ReadFile(opts.TLSClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in client CA %v", opts.TLSClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if opts.TLSRequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, certs, nil
+}
+
+// certificateUser returns the username a verified client certificate was
+// issued to, its common name, or "" when the request carries none.
+func certificateUser(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// authenticateCertificate returns the user named by a verified client
+// certificate. Store failures are returned as is.
+func (s *Server) authenticateCertificate(ctx context.Context, username string) (*db.User, error) {
+	id, err := s.store.UserGetID(ctx, db.User{Username: username})
+	if isStoreFailure(err) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("unknown certificate user")
+	}
+	user, err := s.store.UserGetByID(ctx, id)
+	if isStoreFailure(err) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("unknown certificate user")
+	}
+	if user.Disabled {
+		return nil, fmt.Errorf("certificate user disabled")
+	}
+	user.SystemName, _ = s.store.UserGetSystemName(ctx, user)
+	return &user, nil
+}
+
+// redirectHandler sends plain HTTP requests to the same URL over HTTPS, on the
+// port of the TLS listener at tlsAddr, or the default port when it has none.
+func redirectHandler(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "443" && port != "" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate with its key, PEM encoded, signed by parent or
+// self-signed when parent is nil.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// write stores the certificate and key in dir, returning their paths.
+func (c *testCert) write(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, c.certPEM, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestCert(t, "first", nil)
+	certFile, keyFile := first.write(t, dir)
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second := newTestCert(t, "second", nil)
+	second.write(t, dir)
+	if err := certs.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, _ := certs.getCertificate(nil)
+	if leaf, _ := x509.ParseCertificate(cert.Certificate[0]); leaf.Subject.CommonName != "second" {
+		t.Errorf("expected the renewed certificate, got %v", leaf.Subject.CommonName)
+	}
+
+	os.WriteFile(keyFile, first.keyPEM, 0600)
+	if err := certs.reload(); err == nil {
+		t.Errorf("expected error for a mismatched key pair")
+	}
+	if current, _ := certs.getCertificate(nil); current != cert {
+		t.Errorf("expected the previous certificate to be kept after a failed reload")
+	}
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Errorf("expected error for a missing certificate")
+	}
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	server := newTestServer(t, Options{Registration: true})
+	registerAndLogin(t, server, "certuser")
+
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, ca.certPEM, 0600)
+	certFile, keyFile := newTestCert(t, "127.0.0.1", ca).write(t, dir)
+	config, _, err := tlsConfig(Options{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCA: caFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(server)
+	ts.TLS = config
+	ts.StartTLS()
+	defer ts.Close()
+
+	// Without SNI httptest serves its own certificate, so trust its client's.
+	client := func(commonName string) *http.Client {
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		if commonName != "" {
+			c := newTestCert(t, commonName, ca)
+			transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}}
+		}
+		return &http.Client{Transport: transport}
+	}
+
+	tests := []struct {
+		name       string
+		commonName string
+		status     int
+	}{
+		{"known user", "certuser", http.StatusOK},
+		{"unknown user", "nobody", http.StatusUnauthorized},
+		{"no certificate", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client(tt.commonName).Get(ts.URL + "/api/v1/client-view/status")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected %v, got %v", tt.status, resp.StatusCode)
+			}
+		})
+	}
+
+	// Login accepts the certificate of the user instead of the password.
+	req := doRequest(server, "POST", "/api/v1/login", "", map[string]string{"username": "certuser", "password": "wrong"})
+	if req.Code != http.StatusUnauthorized {
+		t.Errorf("expected %v without a certificate, got %v", http.StatusUnauthorized, req.Code)
+	}
+	resp, err := client("certuser").Post(ts.URL+"/api/v1/login", "application/json", strings.NewReader(`{"username": "certuser", "password": "wrong"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected %v with a certificate, got %v", http.StatusOK, resp.StatusCode)
+	}
+
+	required := httptest.NewUnstartedServer(server)
+	required.TLS = config.Clone()
+	required.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	required.StartTLS()
+	defer required.Close()
+	if resp, err := client("").Get(required.URL + "/ping"); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected the handshake to fail without a client certificate")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		tlsAddr  string
+		url      string
+		location string
+	}{
+		{":443", "http://example.com/api/v1/login?x=1", "https://example.com/api/v1/login?x=1"},
+		{"0.0.0.0:8443", "http://example.com:8080/ping", "https://example.com:8443/ping"},
+		{":443", "http://[::1]:8080/ping", "https://[::1]/ping"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		redirectHandler(tt.tlsAddr).ServeHTTP(w, httptest.NewRequest("POST", tt.url, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.location {
+			t.Errorf("%v: expected redirect to %v, got %v %v", tt.url, tt.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
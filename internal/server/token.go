@@ -0,0 +1,280 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+const (
+	defaultIssuer   = "bashhub-server"
+	defaultAudience = "bashhub"
+	// tokenAlgorithm is the only algorithm tokens are signed or accepted with.
+	tokenAlgorithm = "HS256"
+	// defaultAccessTokenLifetime and defaultRefreshTokenLifetime apply when
+	// Options leaves the lifetimes unset.
+	defaultAccessTokenLifetime  = 15 * time.Minute
+	defaultRefreshTokenLifetime = 30 * 24 * time.Hour
+	// clockSkew is the leeway allowed on nbf and iat for clients whose clock
+	// runs slightly ahead of the server.
+	clockSkew = time.Minute
+)
+
+// tokenEncoding is the unpadded base64url encoding RFC 7515 requires for
+// every JWT segment.
+var tokenEncoding = base64.RawURLEncoding
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// audience is the aud claim, which RFC 7519 allows as a single string or an
+// array of strings.
+type audience []string
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// claims is the token payload: the registered RFC 7519 claims plus the
+// bashhub specific sid, username, systemName and user_id. sid names the
+// session the token belongs to.
+type claims struct {
+	Issuer     string   `json:"iss"`
+	Subject    string   `json:"sub"`
+	Audience   audience `json:"aud"`
+	ExpiresAt  int64    `json:"exp"`
+	NotBefore  int64    `json:"nbf"`
+	IssuedAt   int64    `json:"iat"`
+	ID         string   `json:"jti"`
+	SessionID  string   `json:"sid,omitempty"`
+	Username   string   `json:"username"`
+	SystemName string   `json:"systemName"`
+	UserID     uint     `json:"user_id"`
+}
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// session returns the id of the session the token belongs to. Tokens issued
+// before refresh tokens existed used their jti as the session id.
+func (c *claims) session() string {
+	if c.SessionID != "" {
+		return c.SessionID
+	}
+	return c.ID
+}
+
+// IssueToken starts a session for the named user and returns an access token
+// for it, so operators can script against the API without logging in.
+// Disabled users get no token.
+func (s *Server) IssueToken(ctx context.Context, username string, lifetime time.Duration) (string, error) {
+	id, err := s.store.UserGetID(ctx, db.User{Username: username})
+	if errors.Is(err, db.ErrNotFound) {
+		return "", fmt.Errorf("user %q not found", username)
+	} else if err != nil {
+		return "", err
+	}
+	user, err := s.store.UserGetByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if user.Disabled {
+		return "", fmt.Errorf("user %q is disabled", username)
+	}
+	user.SystemName, _ = s.store.UserGetSystemName(ctx, user)
+	if lifetime <= 0 {
+		lifetime = s.config().AccessTokenLifetime
+	}
+	now := time.Now()
+	sessionID, err := s.createSession(ctx, &user, now, now.Add(lifetime))
+	if err != nil {
+		return "", err
+	}
+	return s.accessToken(&user, sessionID, now, lifetime)
+}
+
+// createSession records a new session for user, so its tokens can be listed
+// and revoked per device.
+func (s *Server) createSession(ctx context.Context, user *db.User, now, expires time.Time) (string, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	err = s.store.SessionCreate(ctx, db.Session{
+		ID:         id,
+		UserID:     user.ID,
+		SystemName: user.SystemName,
+		Created:    now.Unix(),
+		Expires:    expires.Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to record session: %w", err)
+	}
+	return id, nil
+}
+
+// accessToken signs a token for user in the given session.
+func (s *Server) accessToken(user *db.User, sessionID string, now time.Time, lifetime time.Duration) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	return s.signToken(claims{
+		Issuer:     s.config().JWTIssuer,
+		Subject:    user.Username,
+		Audience:   audience{s.config().JWTAudience},
+		ExpiresAt:  now.Add(lifetime).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		ID:         jti,
+		SessionID:  sessionID,
+		Username:   user.Username,
+		SystemName: user.SystemName,
+		UserID:     user.ID,
+	})
+}
+
+func (s *Server) signToken(c claims) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: tokenAlgorithm, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signingInput := tokenEncoding.EncodeToString(header) + "." + tokenEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, s.keys.signing())
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + tokenEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// parseToken verifies the signature and registered claims of a token and
+// returns its payload.
+func (s *Server) parseToken(tokenString string) (*claims, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid token format")
+	}
+
+	headerBytes, err := tokenEncoding.Strict().DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token header encoding")
+	}
+	var header tokenHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("invalid token header")
+	}
+	if header.Alg != tokenAlgorithm {
+		return nil, fmt.Errorf("unexpected signing algorithm %q", header.Alg)
+	}
+	if header.Typ != "" && !strings.EqualFold(header.Typ, "JWT") {
+		return nil, fmt.Errorf("unexpected token type %q", header.Typ)
+	}
+
+	signature, err := tokenEncoding.Strict().DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature encoding")
+	}
+	signingInput := parts[0] + "." + parts[1]
+	valid := false
+	for _, key := range s.keys.verifying(time.Now()) {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signingInput))
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	payload, err := tokenEncoding.Strict().DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token payload encoding")
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, fmt.Errorf("invalid token payload")
+	}
+
+	now := time.Now()
+	switch {
+	case c.ExpiresAt == 0 || now.Unix() > c.ExpiresAt:
+		return nil, fmt.Errorf("token expired")
+	case c.NotBefore != 0 && now.Add(clockSkew).Unix() < c.NotBefore:
+		return nil, fmt.Errorf("token not valid yet")
+	case c.IssuedAt != 0 && now.Add(clockSkew).Unix() < c.IssuedAt:
+		return nil, fmt.Errorf("token issued in the future")
+	case c.Issuer != s.config().JWTIssuer:
+		return nil, fmt.Errorf("unexpected token issuer")
+	case !c.Audience.contains(s.config().JWTAudience):
+		return nil, fmt.Errorf("unexpected token audience")
+	}
+	return &c, nil
+}
+
+// authenticate parses a token and checks that its session has not been
+// revoked, so revocation takes effect on the very next request.
+func (s *Server) authenticate(ctx context.Context, tokenString string) (*claims, error) {
+	c, err := s.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.store.SessionGet(ctx, c.session())
+	if isStoreFailure(err) {
+		return nil, err
+	}
+	if err != nil || session.UserID != c.UserID {
+		return nil, fmt.Errorf("unknown session")
+	}
+	if session.Revoked {
+		return nil, fmt.Errorf("session revoked")
+	}
+	return c, nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pedromol/bashhub-server/internal/db"
+)
+
+// tokenLifetime is the lifetime of the long-lived tokens generateToken issues.
+const tokenLifetime = 10000 * time.Hour
+
+// generateToken starts a session for user and issues a single long-lived
+// token for it, as login does in legacy token mode.
+func (s *Server) generateToken(ctx context.Context, user *db.User) (string, error) {
+	now := time.Now()
+	sessionID, err := s.createSession(ctx, user, now, now.Add(tokenLifetime))
+	if err != nil {
+		return "", err
+	}
+	return s.accessToken(user, sessionID, now, tokenLifetime)
+}
+
+// validateToken authenticates a token the way the auth middleware does and
+// returns the user it was issued to.
+func (s *Server) validateToken(ctx context.Context, tokenString string) (*db.User, error) {
+	c, err := s.authenticate(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &db.User{
+		Username:   c.Username,
+		SystemName: c.SystemName,
+		ID:         c.UserID,
+	}, nil
+}
+
+func decodeSegment(t *testing.T, segment string, v interface{}) {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatalf("segment is not unpadded base64url: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatalf("segment is not json: %v", err)
+	}
+}
+
+// forgeToken signs arbitrary header and payload JSON the way a third party
+// library would, to exercise validation of tokens we did not issue.
+func forgeToken(server *Server, header, payload string) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(payload))
+	mac := hmac.New(sha256.New, server.keys.signing())
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestGenerateTokenFormat(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, Options{})
+	user := createUser(t, `quote"and\backslash`)
+	user.SystemName = "laptop"
+	token, err := server.generateToken(ctx, user)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("expected base64url without padding, got %v", token)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(parts))
+	}
+	var header map[string]string
+	decodeSegment(t, parts[0], &header)
+	if header["alg"] != "HS256" || header["typ"] != "JWT" {
+		t.Errorf("unexpected header %v", header)
+	}
+	var payload map[string]interface{}
+	decodeSegment(t, parts[1], &payload)
+	for _, claim := range []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"} {
+		if _, ok := payload[claim]; !ok {
+			t.Errorf("expected %v claim in %v", claim, payload)
+		}
+	}
+	if payload["username"] != user.Username || payload["iss"] != defaultIssuer || payload["aud"] != defaultAudience {
+		t.Errorf("unexpected payload %v", payload)
+	}
+
+	mac := hmac.New(sha256.New, server.keys.signing())
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != parts[2] {
+		t.Errorf("signature does not verify as HMAC-SHA256 over the signing input")
+	}
+
+	got, err := server.validateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Username != user.Username || got.ID != user.ID || got.SystemName != user.SystemName {
+		t.Errorf("expected %+v, got %+v", user, got)
+	}
+	second, _ := server.generateToken(ctx, user)
+	var secondPayload map[string]interface{}
+	decodeSegment(t, strings.Split(second, ".")[1], &secondPayload)
+	if secondPayload["jti"] == payload["jti"] {
+		t.Errorf("expected unique jti per token")
+	}
+}
+
+func TestValidateTokenRejects(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, Options{})
+	now := time.Now().Unix()
+	validHeader := `{"alg":"HS256","typ":"JWT"}`
+	claimsJSON := func(extra string) string {
+		return `{"iss":"bashhub-server","aud":"bashhub","exp":` + itoa(now+3600) +
+			`,"username":"u","user_id":1` + extra + `}`
+	}
+	valid := forgeToken(server, validHeader, claimsJSON(""))
+	if _, err := server.parseToken(valid); err != nil {
+		t.Fatalf("expected forged but correctly signed token to parse, got %v", err)
+	}
+	if _, err := server.validateToken(ctx, valid); err == nil {
+		t.Errorf("expected token without an issued session to be rejected")
+	}
+	arrayAudience := forgeToken(server, validHeader,
+		`{"iss":"bashhub-server","aud":["other","bashhub"],"exp":`+itoa(now+3600)+`}`)
+	if _, err := server.parseToken(arrayAudience); err != nil {
+		t.Errorf("expected audience array to parse, got %v", err)
+	}
+
+	parts := strings.Split(valid, ".")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "alg none", token: forgeToken(server, `{"alg":"none","typ":"JWT"}`, claimsJSON(""))},
+		{name: "alg HS512", token: forgeToken(server, `{"alg":"HS512","typ":"JWT"}`, claimsJSON(""))},
+		{name: "wrong typ", token: forgeToken(server, `{"alg":"HS256","typ":"JWE"}`, claimsJSON(""))},
+		{name: "expired", token: forgeToken(server, validHeader, `{"iss":"bashhub-server","aud":"bashhub","exp":`+itoa(now-10)+`}`)},
+		{name: "missing exp", token: forgeToken(server, validHeader, `{"iss":"bashhub-server","aud":"bashhub"}`)},
+		{name: "not before", token: forgeToken(server, validHeader, claimsJSON(`,"nbf":`+itoa(now+3600)))},
+		{name: "issued in future", token: forgeToken(server, validHeader, claimsJSON(`,"iat":`+itoa(now+3600)))},
+		{name: "wrong issuer", token: forgeToken(server, validHeader, `{"iss":"evil","aud":"bashhub","exp":`+itoa(now+3600)+`}`)},
+		{name: "wrong audience", token: forgeToken(server, validHeader, `{"iss":"bashhub-server","aud":"other","exp":`+itoa(now+3600)+`}`)},
+		{name: "padded signature", token: valid + "="},
+		{name: "tampered payload", token: parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(claimsJSON(`,"user_id":2`))) + "." + parts[2]},
+		{name: "bad header encoding", token: "!!." + parts[1] + "." + parts[2]},
+		{name: "two segments", token: parts[0] + "." + parts[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := server.parseToken(tt.token); err == nil {
+				t.Errorf("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestCustomIssuerAndAudience(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t, Options{JWTIssuer: "https://bashhub.example.com", JWTAudience: "shell-history"})
+	token, _ := server.generateToken(ctx, createUser(t, "custom-claims"))
+	c, err := server.parseToken(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Issuer != "https://bashhub.example.com" || !c.Audience.contains("shell-history") {
+		t.Errorf("unexpected claims %+v", c)
+	}
+	other := newTestServer(t, Options{})
+	if _, err := other.validateToken(ctx, token); err == nil {
+		t.Errorf("expected token for another audience to be rejected")
+	}
+}
+
+func itoa(v int64) string {
+	return strconv.FormatInt(v, 10)
+}